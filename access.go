@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Per-directory access control file, applying to the directory and
+// everything below it down to the next such file
+const accessFileName = ".access"
+
+// accessPolicy is a parsed .access file, for example:
+//
+//	# anyone may download, only alice may upload
+//	public = true
+//	users = alice
+//
+// Users are matched against the Basic Auth identity, so restricting a
+// directory only lets someone in when -user and -pass are set.
+type accessPolicy struct {
+	public  bool     // public=true, reads need no credentials
+	private bool     // public=false, even reads need credentials
+	users   []string // only these users get in, any user when empty
+}
+
+// parseAccessFile reads the key=value lines of a .access file
+func parseAccessFile(file string) (policy accessPolicy, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return policy, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return policy, fmt.Errorf("%s:%d: expected key=value", file, line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "public":
+			public, err := strconv.ParseBool(value)
+			if err != nil {
+				return policy, fmt.Errorf("%s:%d: public must be true or false", file, line)
+			}
+			policy.public, policy.private = public, !public
+		case "users":
+			for _, user := range strings.Split(value, ",") {
+				if user = strings.TrimSpace(user); user != "" {
+					policy.users = append(policy.users, user)
+				}
+			}
+		default:
+			return policy, fmt.Errorf("%s:%d: unknown key %q", file, line, key)
+		}
+	}
+	return policy, scanner.Err()
+}
+
+// accessPolicyFor finds the .access file nearest to fullPath, looking in
+// its directory and then every parent up to root. found is false when there
+// is none and the global policy applies.
+func accessPolicyFor(root, fullPath string) (policy accessPolicy, found bool, err error) {
+	root, dir := filepath.Clean(root), fullPath
+	if info, err := os.Stat(fullPath); err != nil || !info.IsDir() {
+		dir = filepath.Dir(fullPath)
+	}
+	for {
+		policy, err := parseAccessFile(filepath.Join(dir, accessFileName))
+		if err == nil {
+			return policy, true, nil
+		}
+		if !os.IsNotExist(err) {
+			return policy, false, err
+		}
+		parent := filepath.Dir(dir)
+		if dir == root || parent == dir {
+			return accessPolicy{}, false, nil
+		}
+		dir = parent
+	}
+}
+
+// allows reports whether user, "" when not authenticated, may make a read
+// or write request under the policy
+func (p accessPolicy) allows(user string, read bool) bool {
+	if read && p.public {
+		return true
+	}
+	if user == "" {
+		// Open only when the server itself has no credentials set, which
+		// like basicAuth takes both -user and -pass
+		return !p.private && len(p.users) == 0 && (authUser == "" || authPass == "")
+	}
+	return len(p.users) == 0 || slices.Contains(p.users, user)
+}
+
+// authenticatedUser returns the Basic Auth user when the credentials are
+// valid, and "" otherwise
+func authenticatedUser(r *http.Request) string {
+	if authUser == "" || authPass == "" {
+		return ""
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok || !checkCredentials(user, pass) {
+		return ""
+	}
+	return user
+}
+
+// isAccessFile reports whether a path names a .access file
+func isAccessFile(p string) bool {
+	return path.Base(filepath.ToSlash(p)) == accessFileName
+}
+
+// accessControl applies the .access file nearest to the requested path,
+// falling back to the -user/-pass Basic Auth when there is none. It takes
+// the place of basicAuth when serving a directory.
+func accessControl(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestPath := path.Clean("/" + r.URL.Path)
+		if isAccessFile(requestPath) {
+			http.Error(w, "Access files can't be read or written over HTTP", http.StatusForbidden)
+			return
+		}
+
+		root, fullPath := fsPath(requestPath)
+		policy, _, err := accessPolicyFor(root, fullPath)
+		if err != nil {
+			// Fail closed rather than ignore a broken rule
+			http.Error(w, fmt.Sprintf("Error reading access file: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		user := authenticatedUser(r)
+		if policy.allows(user, isReadMethod(r.Method)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if user == "" && authUser != "" && authPass != "" {
+			logf(r, "Authentication failed from %s", clientIP(r))
+			w.Header().Set("WWW-Authenticate", `Basic realm="go-upload"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Access denied", http.StatusForbidden)
+	})
+}
+
+// readableDir reports whether r may read dir as far as a .access file in dir
+// itself is concerned. Archives and searches use it on subdirectories, the
+// directory they start from has already been checked by accessControl.
+func readableDir(r *http.Request, dir string) bool {
+	policy, err := parseAccessFile(filepath.Join(dir, accessFileName))
+	if os.IsNotExist(err) {
+		return true
+	}
+	return err == nil && policy.allows(authenticatedUser(r), true)
+}
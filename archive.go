@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// serveZip streams a zip archive of dirPath straight to the response.
+// Entries are written as they are read, so the archive is never held in
+// memory. Symlinks and other special files are skipped.
+func serveZip(w http.ResponseWriter, r *http.Request, dirPath string) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", filepath.Base(dirPath)))
+
+	zw := zip.NewWriter(w)
+	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dirPath {
+			return nil
+		}
+		// Leave out access files, names hidden from listings by -hide or
+		// as dotfiles, and subdirectories a .access file closes to this client
+		if d.Name() == accessFileName || isHidden(d.Name()) || (d.IsDir() && !readableDir(r, path)) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if d.IsDir() {
+			header.Name += "/"
+			_, err := zw.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		return copyFileTo(fw, path)
+	})
+	if err != nil {
+		// The response is already under way, so all we can do is log and stop
+		logf(r, "Failed to build zip archive of %s: %v", dirPath, err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		logf(r, "Failed to finish zip archive of %s: %v", dirPath, err)
+		return
+	}
+	logf(r, "Served zip archive: %s", dirPath)
+}
+
+// serveTarGz streams a gzip-compressed tar archive of dirPath straight to the
+// response, keeping file modes and modification times. Like serveZip it never
+// holds the archive in memory and skips symlinks and other special files.
+func serveTarGz(w http.ResponseWriter, r *http.Request, dirPath string) {
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.tar.gz\"", filepath.Base(dirPath)))
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dirPath {
+			return nil
+		}
+		// Leave out access files, names hidden from listings by -hide or
+		// as dotfiles, and subdirectories a .access file closes to this client
+		if d.Name() == accessFileName || isHidden(d.Name()) || (d.IsDir() && !readableDir(r, path)) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		return copyFileTo(tw, path)
+	})
+	if err != nil {
+		// The response is already under way, so all we can do is log and stop
+		logf(r, "Failed to build tar.gz archive of %s: %v", dirPath, err)
+		return
+	}
+	if err := tw.Close(); err != nil {
+		logf(r, "Failed to finish tar.gz archive of %s: %v", dirPath, err)
+		return
+	}
+	if err := gw.Close(); err != nil {
+		logf(r, "Failed to finish tar.gz archive of %s: %v", dirPath, err)
+		return
+	}
+	logf(r, "Served tar.gz archive: %s", dirPath)
+}
+
+// copyFileTo copies the contents of the file at path into w
+func copyFileTo(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = copyBuffered(w, f)
+	return err
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// basicAuth wraps a handler so every request must carry the configured credentials
+func basicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || !checkCredentials(user, pass) {
+			logf(r, "Authentication failed from %s", clientIP(r))
+			w.Header().Set("WWW-Authenticate", `Basic realm="go-upload"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkCredentials compares both values in constant time so a failed
+// attempt doesn't reveal how much of the username or password matched
+func checkCredentials(user, pass string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(authUser)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(authPass)) == 1
+	return userOK && passOK
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// bufferPool hands out -buffer-size byte slices for copying file data
+var bufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, bufferSize)
+		return &buf
+	},
+}
+
+// copyBuffered copies src to dst through a pooled buffer. It stands in for
+// io.Copy on upload and archive paths so -buffer-size sets the chunk size
+// of every read and write.
+func copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	buf := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(buf)
+
+	// Hide ReadFrom and WriteTo, which would make io.CopyBuffer ignore buf
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// benchmarkCopy writes size bytes to a temp file with copyFn, reading through
+// a plain io.Reader like a request body
+func benchmarkCopy(b *testing.B, size int, copyFn func(io.Writer, io.Reader) (int64, error)) {
+	data := bytes.Repeat([]byte{'x'}, size)
+	file, err := os.Create(filepath.Join(b.TempDir(), "copy.bin"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := copyFn(file, struct{ io.Reader }{bytes.NewReader(data)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCopy compares io.Copy, as uploads used before -buffer-size, with
+// copyBuffered at a few buffer sizes
+func BenchmarkCopy(b *testing.B) {
+	const size = 64 << 20
+
+	// The sub-benchmarks resize the shared buffers, put them back afterwards.
+	// A fresh pool drops buffers of the benchmarked sizes.
+	savedSize, newBuffer := bufferSize, bufferPool.New
+	b.Cleanup(func() {
+		bufferSize = savedSize
+		bufferPool = sync.Pool{New: newBuffer}
+	})
+
+	b.Run("io.Copy", func(b *testing.B) {
+		benchmarkCopy(b, size, io.Copy)
+	})
+	for _, bufSize := range []int{32 << 10, 256 << 10, 1 << 20} {
+		b.Run("copyBuffered-"+strconv.Itoa(bufSize>>10)+"KiB", func(b *testing.B) {
+			bufferSize = bufSize
+			bufferPool = sync.Pool{New: newBuffer}
+			benchmarkCopy(b, size, copyBuffered)
+		})
+	}
+}
@@ -1,15 +1,15 @@
 #!/bin/bash
 
 # Windows x86
-GOOS=windows GOARCH=amd64 go build -trimpath -ldflags="-s -w" -o go-upload-windows-amd64.exe main.go
+GOOS=windows GOARCH=amd64 go build -trimpath -ldflags="-s -w" -o go-upload-windows-amd64.exe .
 
 # Windows ARM
-GOOS=windows GOARCH=arm64 go build -trimpath -ldflags="-s -w" -o go-upload-windows-arm64.exe main.go
+GOOS=windows GOARCH=arm64 go build -trimpath -ldflags="-s -w" -o go-upload-windows-arm64.exe .
 
 # Linux x86
-GOOS=linux GOARCH=amd64 go build -trimpath -ldflags="-s -w" -o go-upload-linux-amd64 main.go
+GOOS=linux GOARCH=amd64 go build -trimpath -ldflags="-s -w" -o go-upload-linux-amd64 .
 
 # macOS ARM
-GOOS=darwin GOARCH=arm64 go build -trimpath -ldflags="-s -w" -o go-upload-darwin-arm64 main.go
+GOOS=darwin GOARCH=arm64 go build -trimpath -ldflags="-s -w" -o go-upload-darwin-arm64 .
 
 echo "build completed!"
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileCache is a byte-budgeted LRU of small file contents. Entries are keyed
+// by path and only used while the file's modification time and size match.
+type fileCache struct {
+	mu     sync.Mutex
+	budget int64
+	used   int64
+	items  map[string]*list.Element
+	lru    *list.List // front is most recently used
+}
+
+type cacheItem struct {
+	path    string
+	modTime time.Time
+	data    []byte
+}
+
+// Read cache shared by all downloads, nil unless -cache-size is set
+var contentCache *fileCache
+
+func newFileCache(budget int64) *fileCache {
+	return &fileCache{
+		budget: budget,
+		items:  make(map[string]*list.Element),
+		lru:    list.New(),
+	}
+}
+
+// get returns the cached contents of path if they are still current
+func (c *fileCache) get(path string, info os.FileInfo) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[path]
+	if !ok {
+		return nil, false
+	}
+	item := elem.Value.(*cacheItem)
+	if !item.modTime.Equal(info.ModTime()) || int64(len(item.data)) != info.Size() {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return item.data, true
+}
+
+// put stores data for path, evicting least recently used entries to stay within budget
+func (c *fileCache) put(path string, info os.FileInfo, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[path]; ok {
+		c.remove(elem)
+	}
+	for c.used+int64(len(data)) > c.budget && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+
+	c.items[path] = c.lru.PushFront(&cacheItem{path: path, modTime: info.ModTime(), data: data})
+	c.used += int64(len(data))
+}
+
+// remove drops an entry, the caller must hold c.mu
+func (c *fileCache) remove(elem *list.Element) {
+	item := elem.Value.(*cacheItem)
+	c.lru.Remove(elem)
+	delete(c.items, item.path)
+	c.used -= int64(len(item.data))
+}
+
+// cachedContent returns the file's contents from the read cache, loading it
+// on a miss. ok is false when the cache is disabled, the file is too big or
+// the request asks for a range, in which case the file is served from disk.
+func cachedContent(r *http.Request, filePath string, info os.FileInfo) ([]byte, bool) {
+	if contentCache == nil || r.Header.Get("Range") != "" {
+		return nil, false
+	}
+	if info.Size() > cacheMaxFile || info.Size() > contentCache.budget {
+		return nil, false
+	}
+
+	if data, ok := contentCache.get(filePath, info); ok {
+		return data, true
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil || int64(len(data)) != info.Size() {
+		// Changed while reading, let the disk path deal with it
+		return nil, false
+	}
+	contentCache.put(filePath, info, data)
+	return data, true
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directory under uploadDir holding the -cas objects and manifest
+const casDirName = ".cas"
+
+// Methods served in content-addressable mode
+const casMethods = "GET, HEAD, PUT, POST, DELETE, OPTIONS"
+
+// casEntry is what the manifest records for one stored path
+type casEntry struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// casStore keeps each distinct upload once, named by its SHA-256, and maps
+// request paths to those objects through a JSON manifest
+type casStore struct {
+	mu       sync.Mutex
+	dir      string
+	manifest map[string]casEntry // keyed by cleaned URL path
+}
+
+// Content-addressable store, nil unless -cas is set
+var casObjects *casStore
+
+func openCASStore(root string) (*casStore, error) {
+	s := &casStore{dir: filepath.Join(root, casDirName), manifest: make(map[string]casEntry)}
+	if err := os.MkdirAll(filepath.Join(s.dir, "objects"), dirMode); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.manifestPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.manifest); err != nil {
+			return nil, fmt.Errorf("reading manifest: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *casStore) manifestPath() string {
+	return filepath.Join(s.dir, "manifest.json")
+}
+
+func (s *casStore) objectPath(hash string) string {
+	return filepath.Join(s.dir, "objects", hash[:2], hash)
+}
+
+// save writes the manifest atomically, the caller must hold s.mu
+func (s *casStore) save() error {
+	data, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, "manifest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.manifestPath())
+}
+
+func (s *casStore) lookup(urlPath string) (casEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.manifest[urlPath]
+	return entry, ok
+}
+
+// commit moves a fully written temp file into the object store and points
+// urlPath at it. When the object already exists the temp file is dropped
+// instead, deduplicated reports which happened.
+func (s *casStore) commit(urlPath, tmpPath string, entry casEntry) (deduplicated bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objectPath := s.objectPath(entry.Hash)
+	if _, err := os.Stat(objectPath); err == nil {
+		deduplicated = true
+		os.Remove(tmpPath)
+	} else {
+		if err := os.MkdirAll(filepath.Dir(objectPath), dirMode); err != nil {
+			return false, err
+		}
+		if err := os.Rename(tmpPath, objectPath); err != nil {
+			return false, err
+		}
+	}
+
+	previous, replaced := s.manifest[urlPath]
+	s.manifest[urlPath] = entry
+	if err := s.save(); err != nil {
+		if replaced {
+			s.manifest[urlPath] = previous
+		} else {
+			delete(s.manifest, urlPath)
+		}
+		s.collect(entry.Hash)
+		return false, err
+	}
+	if replaced && previous.Hash != entry.Hash {
+		s.collect(previous.Hash)
+	}
+	return deduplicated, nil
+}
+
+// remove forgets urlPath, or every path below it when recursive is set, and
+// returns how many paths were removed
+func (s *casStore) remove(urlPath string, recursive bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := make(map[string]casEntry)
+	prefix := strings.TrimSuffix(urlPath, "/") + "/"
+	for p, entry := range s.manifest {
+		if p == urlPath || (recursive && strings.HasPrefix(p, prefix)) {
+			removed[p] = entry
+			delete(s.manifest, p)
+		}
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+	if err := s.save(); err != nil {
+		for p, entry := range removed {
+			s.manifest[p] = entry
+		}
+		return 0, err
+	}
+	for _, entry := range removed {
+		s.collect(entry.Hash)
+	}
+	return len(removed), nil
+}
+
+// collect deletes an object once no path refers to it, the caller must hold s.mu
+func (s *casStore) collect(hash string) {
+	for _, entry := range s.manifest {
+		if entry.Hash == hash {
+			return
+		}
+	}
+	os.Remove(s.objectPath(hash))
+}
+
+// children lists what lies directly under the directory dir. Directories
+// only exist implicitly as prefixes of stored paths. ok is false when
+// nothing is stored under dir.
+func (s *casStore) children(dir string) (entries []os.DirEntry, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	files := make(map[string]casFileInfo)
+	for p, entry := range s.manifest {
+		rest, found := strings.CutPrefix(p, prefix)
+		if !found {
+			continue
+		}
+		name, below, isDir := strings.Cut(rest, "/")
+		if isDir && below != "" {
+			// A directory is as recent as the newest file inside it
+			info := files[name]
+			if entry.ModTime.After(info.modTime) {
+				info.modTime = entry.ModTime
+			}
+			info.name, info.dir = name, true
+			files[name] = info
+			continue
+		}
+		files[name] = casFileInfo{name: name, size: entry.Size, modTime: entry.ModTime}
+	}
+
+	for _, info := range files {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	// Match os.ReadDir, which returns entries sorted by name
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, len(entries) > 0
+}
+
+// casFileInfo describes a manifest path or implied directory as an os.FileInfo
+type casFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i casFileInfo) Name() string       { return i.name }
+func (i casFileInfo) Size() int64        { return i.size }
+func (i casFileInfo) ModTime() time.Time { return i.modTime }
+func (i casFileInfo) IsDir() bool        { return i.dir }
+func (i casFileInfo) Sys() any           { return nil }
+
+func (i casFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | dirMode
+	}
+	return fileMode
+}
+
+// Handle requests in -cas mode - paths resolve through the manifest instead
+// of the directory tree. Only plain uploads, downloads and deletes are supported.
+func handleCAS(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		handleCASGet(w, r)
+	case http.MethodPut:
+		handleCASPut(w, r)
+	case http.MethodPost:
+		// Raw POST bodies are uploads, forms and file operations aren't supported
+		if isMultipartForm(r) || r.URL.Query().Get("action") != "" {
+			http.Error(w, "Method not supported in content-addressable mode", http.StatusNotImplemented)
+			return
+		}
+		handleCASPut(w, r)
+	case http.MethodDelete:
+		handleCASDelete(w, r)
+	case http.MethodOptions:
+		w.Header().Set("Allow", casMethods)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", casMethods)
+		http.Error(w, "Method not supported in content-addressable mode", http.StatusNotImplemented)
+	}
+}
+
+// Handle GET and HEAD in -cas mode - serve a stored object or list the
+// paths below a directory
+func handleCASGet(w http.ResponseWriter, r *http.Request) {
+	urlPath := path.Clean("/" + r.URL.Path)
+	if hideStrict && isHiddenPath(urlPath) {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+
+	if entry, ok := casObjects.lookup(urlPath); ok {
+		serveCASObject(w, r, urlPath, entry)
+		return
+	}
+
+	entries, ok := casObjects.children(urlPath)
+	if !ok && urlPath != "/" {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	if noListing {
+		http.Error(w, "Directory listing is disabled", http.StatusForbidden)
+		return
+	}
+	if dirRedirect && !strings.HasSuffix(r.URL.Path, "/") && !wantsJSON(r) {
+		target := url.URL{Path: r.URL.Path + "/", RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+		return
+	}
+	serveListing(w, r, urlPath, entries)
+}
+
+// serveCASObject sends the object stored for urlPath. Its type and file name
+// come from urlPath, and the content hash doubles as a strong ETag.
+func serveCASObject(w http.ResponseWriter, r *http.Request, urlPath string, entry casEntry) {
+	file, err := os.Open(casObjects.objectPath(entry.Hash))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error opening stored object: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	mimeType := mimeOverrides.lookup(urlPath)
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(path.Ext(urlPath))
+	}
+	if mimeType == "" {
+		// Sniff the content, then rewind so it's served from the start
+		buf := make([]byte, 512)
+		n, _ := io.ReadFull(file, buf)
+		mimeType = http.DetectContentType(buf[:n])
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, fmt.Sprintf("Error reading stored object: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if !isTextMimeType(mimeType) {
+		disposition := "attachment"
+		if r.URL.Query().Get("inline") == "1" {
+			disposition = "inline"
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, path.Base(urlPath)))
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("ETag", `"`+entry.Hash+`"`)
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	if r.URL.Query().Get("checksum") == "1" {
+		w.Header().Set("X-Checksum-SHA256", entry.Hash)
+	}
+
+	// Count what is actually sent for /stats
+	w, finishCount := countTransfer(w, urlPath)
+	defer finishCount()
+
+	// ServeContent handles the conditional and Range headers
+	http.ServeContent(w, r, path.Base(urlPath), entry.ModTime, file)
+}
+
+// Handle PUT in -cas mode - hash the body into a temp file, then keep it as
+// objects/<hash> unless identical content is already stored
+func handleCASPut(w http.ResponseWriter, r *http.Request) {
+	// Bound the number of uploads hitting the disk at once
+	if !acquireUploadSlot(w, r) {
+		return
+	}
+	defer releaseUploadSlot()
+
+	// Keep slow but steady uploads alive past -read-timeout
+	extendReadDeadline(w, r)
+
+	// Directories only exist as prefixes of stored paths
+	urlPath := path.Clean("/" + r.URL.Path)
+	if urlPath == "/" || strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+	if !ensureNameLengths(w, urlPath) || !ensureAllowedExtension(w, urlPath) {
+		return
+	}
+	if r.Header.Get("Content-Range") != "" {
+		http.Error(w, "Resumable uploads are not supported in content-addressable mode", http.StatusNotImplemented)
+		return
+	}
+
+	// Enforce the maximum upload size, rejecting early when the length is known
+	if maxSize > 0 {
+		if r.ContentLength > maxSize {
+			http.Error(w, fmt.Sprintf("File exceeds maximum upload size of %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+
+	file, err := os.CreateTemp(filepath.Join(casObjects.dir, "objects"), ".upload-*")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	tmpPath := file.Name()
+	defer func() {
+		file.Close()
+		// Gone once committed, either renamed or dropped as a duplicate
+		os.Remove(tmpPath)
+	}()
+
+	// A body sent with Content-Encoding: gzip is stored as the original file
+	body := io.Reader(r.Body)
+	gzipped := gzipEncoded(r)
+	if gzipped {
+		var ok bool
+		if body, ok = decodeGzipBody(w, body); !ok {
+			return
+		}
+	}
+
+	hash := sha256.New()
+	written, err := copyBuffered(file, io.TeeReader(body, hash))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, fmt.Sprintf("File exceeds maximum upload size of %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if isTimeout(err) {
+			http.Error(w, "Upload stalled, request timed out", http.StatusRequestTimeout)
+			return
+		}
+		if gzipped && isCorruptGzip(err) {
+			http.Error(w, fmt.Sprintf("Corrupt gzip body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if diskFull(w, r, err) {
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to write file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := file.Chmod(fileMode); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to set file mode: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := file.Close(); err != nil {
+		if diskFull(w, r, err) {
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to write file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	digest := hex.EncodeToString(hash.Sum(nil))
+	_, replaced := casObjects.lookup(urlPath)
+	deduplicated, err := casObjects.commit(urlPath, tmpPath, casEntry{Hash: digest, Size: written, ModTime: time.Now()})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to store file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if deduplicated {
+		logf(r, "Uploaded file: %s (%d bytes, deduplicated as %s)", urlPath, written, digest)
+	} else {
+		logf(r, "Uploaded file: %s (%d bytes, stored as %s)", urlPath, written, digest)
+	}
+	notifyUpload(r, urlPath, written)
+
+	// 201 for a new path, 200 when an existing one was replaced
+	status, message := http.StatusCreated, "File uploaded successfully"
+	if replaced {
+		status, message = http.StatusOK, "File replaced successfully"
+	} else {
+		w.Header().Set("Location", absoluteURL(r, urlPath))
+	}
+	if wantsJSON(r) {
+		writeUploadJSON(w, status, newUploadResult(urlPath, written, digest))
+		return
+	}
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s: %s (%d bytes)\n", message, strings.TrimPrefix(urlPath, "/"), written)
+}
+
+// Handle DELETE in -cas mode - drop a path, or everything below a directory
+// given with a trailing slash. Objects go once no path refers to them.
+func handleCASDelete(w http.ResponseWriter, r *http.Request) {
+	// Require an explicit confirmation so prefetchers and stray clients can't delete files
+	if r.URL.Query().Get("confirm") != "yes" {
+		http.Error(w, "Deletion requires confirmation, add ?confirm=yes to the request", http.StatusBadRequest)
+		return
+	}
+
+	urlPath := path.Clean("/" + r.URL.Path)
+	if urlPath == "/" {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+
+	recursive := strings.HasSuffix(r.URL.Path, "/")
+	if _, isFile := casObjects.lookup(urlPath); !isFile && !recursive {
+		if _, isDir := casObjects.children(urlPath); isDir {
+			http.Error(w, "Path is a directory, add a trailing slash to delete it", http.StatusConflict)
+			return
+		}
+	}
+
+	removed, err := casObjects.remove(urlPath, recursive)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if removed == 0 {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	logf(r, "Deleted: %s (%d path(s))", urlPath, removed)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
+)
+
+// Upper bound on cached digests, the cache is reset once it is reached
+const maxChecksumEntries = 1024
+
+// checksumEntry is a cached digest of one version of a file
+type checksumEntry struct {
+	modTime time.Time
+	size    int64
+	digest  string
+}
+
+var (
+	checksumMu    sync.Mutex
+	checksumCache = make(map[string]checksumEntry)
+)
+
+// fileSHA256 returns the hex encoded SHA-256 of the file at path. Digests are
+// cached per path and reused while the modification time and size are
+// unchanged. The first request for a large file has to read all of it, so it
+// will be noticeably slower than later ones.
+func fileSHA256(path string, info os.FileInfo) (string, error) {
+	checksumMu.Lock()
+	entry, ok := checksumCache[path]
+	checksumMu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.digest, nil
+	}
+
+	hasher := sha256.New()
+	if err := copyFileTo(hasher, path); err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(hasher.Sum(nil))
+
+	checksumMu.Lock()
+	if len(checksumCache) >= maxChecksumEntries {
+		checksumCache = make(map[string]checksumEntry)
+	}
+	checksumCache[path] = checksumEntry{modTime: info.ModTime(), size: info.Size(), digest: digest}
+	checksumMu.Unlock()
+
+	return digest, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Page used for error responses, nil unless -error-template is set
+var errorTemplate *template.Template
+
+// errorPageData is what -error-template is executed with
+type errorPageData struct {
+	Path       string
+	Status     int
+	StatusText string
+	Message    string
+}
+
+// errorPageWriter holds back plain text error responses, as written by
+// http.Error, so they can be replaced by the error template
+type errorPageWriter struct {
+	http.ResponseWriter
+	status    int
+	message   bytes.Buffer
+	capturing bool
+}
+
+func (ew *errorPageWriter) WriteHeader(status int) {
+	if status >= 400 && strings.HasPrefix(ew.Header().Get("Content-Type"), "text/plain") {
+		ew.status = status
+		ew.capturing = true
+		return
+	}
+	ew.ResponseWriter.WriteHeader(status)
+}
+
+func (ew *errorPageWriter) Write(b []byte) (int, error) {
+	if ew.capturing {
+		return ew.message.Write(b)
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (ew *errorPageWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// finish renders a captured error through the template, falling back to the
+// original plain text if the template fails
+func (ew *errorPageWriter) finish(r *http.Request) {
+	if !ew.capturing {
+		return
+	}
+
+	message := strings.TrimSpace(ew.message.String())
+	var page bytes.Buffer
+	err := errorTemplate.Execute(&page, errorPageData{
+		Path:       r.URL.Path,
+		Status:     ew.status,
+		StatusText: http.StatusText(ew.status),
+		Message:    message,
+	})
+	if err != nil {
+		logf(r, "Failed to render error template: %v", err)
+		ew.ResponseWriter.WriteHeader(ew.status)
+		ew.ResponseWriter.Write(ew.message.Bytes())
+		return
+	}
+
+	ew.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ew.ResponseWriter.WriteHeader(ew.status)
+	ew.ResponseWriter.Write(page.Bytes())
+}
+
+// errorPages renders error responses with -error-template
+func errorPages(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorPageWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		ew.finish(r)
+	})
+}
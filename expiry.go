@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// startExpirySweeper deletes files older than ttl from every served root in
+// the background, checking at most once a minute
+func startExpirySweeper(ttl time.Duration) {
+	interval := min(ttl, time.Minute)
+	log.Printf("Expiring files after %s", ttl)
+	go func() {
+		for range time.Tick(interval) {
+			sweepExpired(uploadDir, ttl)
+			for _, m := range rootMaps {
+				sweepExpired(m.dir, ttl)
+			}
+		}
+	}()
+}
+
+// sweepExpired removes files under root last modified more than ttl ago, then
+// prunes directories left empty. Directories are only pruned once they're as
+// old as the TTL, so one just created for an upload in progress is kept.
+// .access files are left alone.
+func sweepExpired(root string, ttl time.Duration) {
+	cutoff := time.Now().Add(-ttl)
+	var dirs []string
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Expiry: skipping %s: %v", path, err)
+			return nil
+		}
+		if path == root {
+			return nil
+		}
+		// Access policies outlive the files they apply to. Upload temp files
+		// and partial resumable uploads expire by age like anything else,
+		// so abandoned ones don't pile up.
+		if d.Name() == accessFileName && !d.IsDir() {
+			return nil
+		}
+		if d.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("Expiry: failed to remove %s: %v", path, err)
+			return nil
+		}
+		invalidateUsage(filepath.Dir(path))
+		log.Printf("Expired file: %s (modified %s)", path, info.ModTime().Format(time.RFC3339))
+		return nil
+	})
+	if err != nil {
+		log.Printf("Expiry: failed to walk %s: %v", root, err)
+	}
+
+	// Deepest first, so parents emptied by their children go too
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Stat(dirs[i])
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		// Remove refuses directories that still have entries
+		if os.Remove(dirs[i]) == nil {
+			invalidateUsage(filepath.Dir(dirs[i]))
+			log.Printf("Expired empty directory: %s", dirs[i])
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// extensionSet is a flag.Value holding a comma-separated list of file
+// extensions, stored lowercase without the leading dot
+type extensionSet map[string]bool
+
+var (
+	// Extensions uploads are limited to, empty allows everything
+	allowedExts = extensionSet{}
+
+	// Extensions that are never accepted, checked after allowedExts
+	deniedExts = extensionSet{"exe": true, "sh": true, "php": true, "bat": true}
+)
+
+func (s extensionSet) String() string {
+	exts := make([]string, 0, len(s))
+	for ext := range s {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return strings.Join(exts, ",")
+}
+
+// Set replaces the set with the extensions in value
+func (s extensionSet) Set(value string) error {
+	for ext := range s {
+		delete(s, ext)
+	}
+	for _, ext := range strings.Split(value, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			s[ext] = true
+		}
+	}
+	return nil
+}
+
+// contains reports whether name's extension is in the set, ignoring case
+func (s extensionSet) contains(name string) bool {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	return s[ext]
+}
+
+// ensureAllowedExtension writes 415 Unsupported Media Type and returns false
+// when name's extension is missing from -allow-ext or listed in -deny-ext
+func ensureAllowedExtension(w http.ResponseWriter, name string) bool {
+	if (len(allowedExts) > 0 && !allowedExts.contains(name)) || deniedExts.contains(name) {
+		http.Error(w, "File type not allowed: "+filepath.Base(name), http.StatusUnsupportedMediaType)
+		return false
+	}
+	return true
+}
+
+// mimeOverrideMap is a flag.Value collecting repeated ext=type pairs
+type mimeOverrideMap map[string]string
+
+// MIME types by lowercase extension, consulted before the system table
+var mimeOverrides = mimeOverrideMap{}
+
+func (m mimeOverrideMap) String() string {
+	pairs := make([]string, 0, len(m))
+	for ext, mimeType := range m {
+		pairs = append(pairs, ext+"="+mimeType)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set adds one ext=type override
+func (m mimeOverrideMap) Set(value string) error {
+	ext, mimeType, ok := strings.Cut(value, "=")
+	ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+	mimeType = strings.TrimSpace(mimeType)
+	if !ok || ext == "" || mimeType == "" {
+		return errors.New("expected ext=type")
+	}
+	if _, _, err := mime.ParseMediaType(mimeType); err != nil {
+		return fmt.Errorf("invalid MIME type %q: %v", mimeType, err)
+	}
+	m[ext] = mimeType
+	return nil
+}
+
+// lookup returns the override for name's extension, or an empty string
+func (m mimeOverrideMap) lookup(name string) string {
+	return m[strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))]
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"net/http"
+	"time"
+)
+
+//go:embed static/favicon.ico
+var staticFiles embed.FS
+
+// Start time stands in for the embedded files' modification time
+var startTime = time.Now()
+
+// Handle /favicon.ico - serve the built-in icon without touching the upload
+// directory, so browsers asking for it don't fill the log with 404s
+func handleFavicon(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	icon, err := staticFiles.ReadFile("static/favicon.ico")
+	if err != nil {
+		http.Error(w, "Favicon not available", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/x-icon")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "favicon.ico", startTime, bytes.NewReader(icon))
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileOp holds the resolved source and destination of a MOVE or COPY request
+type fileOp struct {
+	srcPath, dstPath string // cleaned request paths, for messages
+	srcRoot, dstRoot string
+	src, dst         string // locations on disk
+	srcInfo          os.FileInfo
+	dstInfo          os.FileInfo // nil when the destination doesn't exist yet
+}
+
+// destinationPath reads the target of a MOVE or COPY from the WebDAV style
+// Destination header, which may be an absolute URL, or the dest query parameter
+func destinationPath(r *http.Request) (string, error) {
+	dest := r.Header.Get("Destination")
+	if dest == "" {
+		dest = r.URL.Query().Get("dest")
+	}
+	if dest == "" {
+		return "", errors.New("missing Destination header or dest parameter")
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", fmt.Errorf("invalid destination: %v", err)
+	}
+	return u.Path, nil
+}
+
+// prepareFileOp resolves and checks both ends of a MOVE or COPY. On failure
+// an error response is written and ok is false.
+func prepareFileOp(w http.ResponseWriter, r *http.Request) (op fileOp, ok bool) {
+	// Clean both paths to prevent directory traversal attacks
+	op.srcPath = filepath.Clean(r.URL.Path)
+	dest, err := destinationPath(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return op, false
+	}
+	op.dstPath = filepath.Clean(dest)
+	if !ensureNameLengths(w, op.dstPath) || !ensureNoDotfiles(w, op.dstPath) {
+		return op, false
+	}
+	if maxDepth > 0 && pathDepth(op.dstPath) > maxDepth {
+		http.Error(w, fmt.Sprintf("Destination exceeds the maximum depth of %d", maxDepth), http.StatusBadRequest)
+		return op, false
+	}
+
+	op.srcRoot, op.src = fsPath(op.srcPath)
+	op.dstRoot, op.dst = fsPath(op.dstPath)
+	if op.src == op.srcRoot || op.dst == op.dstRoot {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return op, false
+	}
+
+	// The source was checked by accessControl, the destination must allow writes too
+	if isAccessFile(op.dstPath) {
+		http.Error(w, "Access files can't be read or written over HTTP", http.StatusForbidden)
+		return op, false
+	}
+	policy, _, err := accessPolicyFor(op.dstRoot, op.dst)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading access file: %v", err), http.StatusInternalServerError)
+		return op, false
+	}
+	if !policy.allows(authenticatedUser(r), false) {
+		http.Error(w, "Access denied to destination", http.StatusForbidden)
+		return op, false
+	}
+	if op.src == op.dst {
+		http.Error(w, "Source and destination are the same", http.StatusBadRequest)
+		return op, false
+	}
+
+	// Refuse symlinks that lead outside the upload directory on either side
+	if !ensureWithinRoot(w, op.srcRoot, op.src) || !ensureWithinRoot(w, op.dstRoot, op.dst) {
+		return op, false
+	}
+
+	op.srcInfo, err = os.Stat(op.src)
+	if os.IsNotExist(err) {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return op, false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error accessing path: %v", err), http.StatusInternalServerError)
+		return op, false
+	}
+	if op.srcInfo.IsDir() && strings.HasPrefix(op.dst, op.src+string(filepath.Separator)) {
+		http.Error(w, "Cannot place a directory inside itself", http.StatusConflict)
+		return op, false
+	}
+	// A file can't be given a name an upload wouldn't be allowed
+	if !op.srcInfo.IsDir() && !ensureAllowedExtension(w, op.dst) {
+		return op, false
+	}
+
+	// An existing destination is only replaced with "Overwrite: true" (or WebDAV's "T"),
+	// and never by something of a different kind
+	op.dstInfo, err = os.Stat(op.dst)
+	if err == nil {
+		if !allowOverwrite(r) {
+			http.Error(w, "Destination already exists", http.StatusConflict)
+			return op, false
+		}
+		if op.dstInfo.IsDir() != op.srcInfo.IsDir() {
+			http.Error(w, "Cannot replace a file with a directory or a directory with a file", http.StatusConflict)
+			return op, false
+		}
+	} else if !os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("Error accessing destination: %v", err), http.StatusInternalServerError)
+		return op, false
+	}
+
+	if !ensureParentDir(w, filepath.Dir(op.dst)) {
+		return op, false
+	}
+	return op, true
+}
+
+// allowOverwrite reports whether the request permits replacing its destination
+func allowOverwrite(r *http.Request) bool {
+	value := strings.ToLower(strings.TrimSpace(r.Header.Get("Overwrite")))
+	return value == "true" || value == "t"
+}
+
+// finishFileOp answers 201 for a new destination and 204 for a replaced one
+func finishFileOp(w http.ResponseWriter, r *http.Request, op fileOp) {
+	invalidateUsage(filepath.Dir(op.dst))
+	invalidateUsage(op.dst)
+	if op.dstInfo != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Location", absoluteURL(r, op.dstPath))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Handle MOVE requests - rename a file or directory
+func handleMove(w http.ResponseWriter, r *http.Request) {
+	op, ok := prepareFileOp(w, r)
+	if !ok {
+		return
+	}
+
+	// A file joins the destination directory's quota. A moved directory keeps
+	// its contents, and with them the usage it already had.
+	if !op.srcInfo.IsDir() && filepath.Dir(op.src) != filepath.Dir(op.dst) {
+		if !ensureQuota(w, op.dst, op.srcInfo.Size()) {
+			return
+		}
+	}
+
+	// Renaming can't replace a non-empty directory, so clear it first
+	if op.dstInfo != nil && op.dstInfo.IsDir() {
+		if err := os.RemoveAll(op.dst); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to replace destination: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := os.Rename(op.src, op.dst); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to move: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	invalidateUsage(filepath.Dir(op.src))
+	invalidateUsage(op.src)
+	logf(r, "Moved: %s -> %s", op.src, op.dst)
+	finishFileOp(w, r, op)
+}
+
+// Handle COPY requests - duplicate a file or directory server-side
+func handleCopy(w http.ResponseWriter, r *http.Request) {
+	op, ok := prepareFileOp(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	if op.srcInfo.IsDir() {
+		if !ensureTreeQuota(w, op.src) {
+			return
+		}
+		// Replace rather than merge into an existing directory
+		if op.dstInfo != nil {
+			if err := os.RemoveAll(op.dst); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to replace destination: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		err = copyDir(op.src, op.dst)
+	} else {
+		if !ensureQuota(w, op.dst, op.srcInfo.Size()) {
+			return
+		}
+		err = copyFile(op.src, op.dst)
+	}
+	if err != nil {
+		if diskFull(w, r, err) {
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to copy: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	logf(r, "Copied: %s -> %s", op.src, op.dst)
+	finishFileOp(w, r, op)
+}
+
+// copyFile streams src into a temp file next to dst and renames it into
+// place, so a failed copy never leaves a truncated destination
+func copyFile(src, dst string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := copyFileTo(tmp, src); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(fileMode); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// copyDir recreates the tree under src at dst. Symlinks and other special
+// files are skipped.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, dirMode)
+		case d.Type().IsRegular():
+			return copyFile(path, target)
+		}
+		return nil
+	})
+}
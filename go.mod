@@ -1,3 +1,22 @@
 module github.com/MountToSee/go-upload
 
 go 1.21
+
+require (
+	github.com/alecthomas/chroma/v2 v2.15.0
+	github.com/prometheus/client_golang v1.19.1
+	github.com/yuin/goldmark v1.7.8
+	golang.org/x/net v0.20.0
+	golang.org/x/time v0.10.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dlclark/regexp2 v1.11.4 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
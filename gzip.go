@@ -0,0 +1,127 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// gzipResponseWriter compresses everything written through it
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	// Any length computed for the original body no longer applies
+	g.Header().Del("Content-Length")
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	// Start the gzip stream lazily so bodiless responses (304, HEAD) stay empty
+	if g.gz == nil {
+		g.Header().Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	return g.gz.Write(b)
+}
+
+// Close flushes the remaining compressed data, if any body was written
+func (g *gzipResponseWriter) Close() error {
+	if g.gz == nil {
+		return nil
+	}
+	return g.gz.Close()
+}
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding, params, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(encoding) != "gzip" {
+			continue
+		}
+		// "gzip;q=0" explicitly refuses it
+		return strings.ReplaceAll(params, " ", "") != "q=0"
+	}
+	return false
+}
+
+// maybeGzip wraps w with gzip compression when it is enabled, the client
+// accepts it and the content is text. Already-compressed binaries such as
+// images or archives are never touched. The returned func must be called
+// once the body is complete to flush the compressed stream.
+func maybeGzip(w http.ResponseWriter, r *http.Request, mimeType string) (http.ResponseWriter, func()) {
+	if !enableGzip || !isTextMimeType(mimeType) || !acceptsGzip(r) {
+		return w, func() {}
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+	gw := &gzipResponseWriter{ResponseWriter: w}
+	return gw, func() { gw.Close() }
+}
+
+// servePrecompressed sends filePath+".gz" with Content-Encoding: gzip in place
+// of filePath, when the client accepts gzip and the sibling is a regular file
+// newer than the original. The caller sets Content-Type from the original.
+// It reports false, having written nothing, when there is no usable sibling.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, filePath string, info os.FileInfo) bool {
+	// Byte ranges would refer to the compressed file, not the original
+	if r.Header.Get("Range") != "" || !acceptsGzip(r) {
+		return false
+	}
+
+	// Lstat so a symlinked sibling can't lead outside the upload directory
+	gzPath := filePath + ".gz"
+	gzInfo, err := os.Lstat(gzPath)
+	if err != nil || !gzInfo.Mode().IsRegular() || !gzInfo.ModTime().After(info.ModTime()) {
+		return false
+	}
+	file, err := os.Open(gzPath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+	logf(r, "Serving pre-compressed file: %s", gzPath)
+	http.ServeContent(w, r, filePath, info.ModTime(), file)
+	return true
+}
+
+// gzipEncoded reports whether an upload body should be stored decompressed,
+// which is when it's sent with Content-Encoding: gzip and -store-compressed
+// isn't set
+func gzipEncoded(r *http.Request) bool {
+	return !storeCompressed && strings.EqualFold(strings.TrimSpace(r.Header.Get("Content-Encoding")), "gzip")
+}
+
+// decodeGzipBody decompresses an upload body. The decompressed size is held
+// to -max-size too, so a small body can't inflate without bound. On failure
+// a 400 response is written and ok is false.
+func decodeGzipBody(w http.ResponseWriter, body io.Reader) (decoded io.Reader, ok bool) {
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid gzip body: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	if maxSize > 0 {
+		return http.MaxBytesReader(w, io.NopCloser(gz), maxSize), true
+	}
+	return gz, true
+}
+
+// isCorruptGzip reports whether err comes from a damaged or truncated gzip stream
+func isCorruptGzip(err error) bool {
+	var corrupt flate.CorruptInputError
+	return errors.Is(err, gzip.ErrChecksum) || errors.Is(err, gzip.ErrHeader) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &corrupt)
+}
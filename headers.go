@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerList is a flag.Value collecting repeated "Name: Value" headers
+type headerList []headerField
+
+type headerField struct {
+	name, value string
+}
+
+// Headers added to every response, from -header
+var extraHeaders headerList
+
+func (l *headerList) String() string {
+	var parts []string
+	for _, h := range *l {
+		parts = append(parts, h.name+": "+h.value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Set adds one "Name: Value" header
+func (l *headerList) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	name, val = strings.TrimSpace(name), strings.TrimSpace(val)
+	if !ok || name == "" {
+		return errors.New(`expected "Name: Value"`)
+	}
+	if !isHeaderToken(name) {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if strings.ContainsFunc(val, func(r rune) bool { return r < ' ' && r != '\t' || r == 0x7f }) {
+		return fmt.Errorf("invalid value for header %s", name)
+	}
+	*l = append(*l, headerField{name: http.CanonicalHeaderKey(name), value: val})
+	return nil
+}
+
+// isHeaderToken reports whether s is a valid header field name (an RFC 9110 token)
+func isHeaderToken(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// addHeaders sets the -header headers on every response before the handler
+// runs, so handlers can still override them
+func addHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range extraHeaders {
+			w.Header().Add(h.name, h.value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
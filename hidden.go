@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// patternList is a flag.Value holding comma-separated filepath.Match globs
+type patternList []string
+
+// Names left out of listings and search results
+var hidePatterns = patternList{".*"}
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+// Set replaces the list with the patterns in value
+func (p *patternList) Set(value string) error {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return err
+		}
+		patterns = append(patterns, pattern)
+	}
+	*p = patterns
+	return nil
+}
+
+// isHidden reports whether name matches one of the -hide patterns, or is a
+// dotfile and -allow-dotfiles isn't set
+func isHidden(name string) bool {
+	if !allowDotfiles && strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, pattern := range hidePatterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isHiddenPath reports whether any segment of a URL path is hidden
+func isHiddenPath(urlPath string) bool {
+	for _, segment := range strings.Split(path.Clean("/"+urlPath), "/") {
+		if segment != "" && isHidden(segment) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterHidden drops hidden entries from a directory listing
+func filterHidden(entries []os.DirEntry) []os.DirEntry {
+	visible := entries[:0]
+	for _, entry := range entries {
+		if !isHidden(entry.Name()) {
+			visible = append(visible, entry)
+		}
+	}
+	return visible
+}
+
+// hasDotSegment reports whether any segment of a URL path starts with a dot.
+// Files like .env, .git or .htpasswd often hold secrets and are refused
+// outright unless -allow-dotfiles is set, -hide only keeps them out of listings.
+func hasDotSegment(urlPath string) bool {
+	for _, segment := range strings.Split(path.Clean("/"+urlPath), "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureNoDotfiles writes a 403 response and returns false when urlPath has
+// a dot segment and -allow-dotfiles isn't set
+func ensureNoDotfiles(w http.ResponseWriter, urlPath string) bool {
+	if !allowDotfiles && hasDotSegment(urlPath) {
+		http.Error(w, "Dotfiles are not served", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// Extensions treated as source code for -highlight. Markup that browsers
+// render themselves, such as .html, is deliberately left out.
+var sourceExtensions = map[string]bool{
+	".c": true, ".h": true, ".cc": true, ".cpp": true, ".hpp": true, ".cs": true,
+	".go": true, ".rs": true, ".java": true, ".kt": true, ".scala": true, ".swift": true,
+	".py": true, ".rb": true, ".php": true, ".pl": true, ".lua": true,
+	".js": true, ".ts": true, ".jsx": true, ".tsx": true, ".css": true,
+	".sh": true, ".bash": true, ".ps1": true, ".bat": true,
+	".sql": true, ".json": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true,
+	".xml": true, ".proto": true, ".diff": true, ".patch": true,
+}
+
+// isSourceFile reports whether filePath looks like source code worth highlighting
+func isSourceFile(filePath string) bool {
+	return sourceExtensions[strings.ToLower(filepath.Ext(filePath))]
+}
+
+// wantsHighlight reports whether a source file should be sent as a
+// highlighted page. Only browsers navigating to the file ask for text/html,
+// script and stylesheet loads or fetch() calls get the raw file. ?highlight=1
+// and ?raw=1 override the Accept header either way.
+func wantsHighlight(r *http.Request) bool {
+	switch {
+	case r.URL.Query().Get("raw") == "1":
+		return false
+	case r.URL.Query().Get("highlight") == "1":
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// serveHighlighted renders a source file as syntax highlighted HTML
+func serveHighlighted(w http.ResponseWriter, r *http.Request, filePath string) {
+	source, err := os.ReadFile(filePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	lexer := lexers.Match(filepath.Base(filePath))
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	iterator, err := lexer.Tokenise(nil, string(source))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error highlighting file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w, closeGzip := maybeGzip(w, r, "text/html")
+	defer closeGzip()
+
+	logf(r, "Serving highlighted source: %s (lexer: %s)", filePath, lexer.Config().Name)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	formatter := chromahtml.New(chromahtml.Standalone(true), chromahtml.WithLineNumbers(true))
+	if err := formatter.Format(w, styles.Get("github"), iterator); err != nil {
+		logf(r, "Failed to write highlighted source %s: %v", filePath, err)
+	}
+}
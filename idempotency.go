@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// How long a completed idempotency key is remembered
+const idempotencyTTL = 24 * time.Hour
+
+var (
+	errKeyInFlight = errors.New("an upload with this idempotency key is in progress")
+	errKeyReused   = errors.New("idempotency key was used for a different path")
+)
+
+// idempotentUpload is what's remembered about one X-Idempotency-Key
+type idempotentUpload struct {
+	path     string
+	uploadID string
+	result   *uploadResult // nil while the upload is still in progress
+	expires  time.Time
+}
+
+// idempotencyStore remembers completed PUTs by client supplied key so a
+// retried request can be answered without writing the file again
+type idempotencyStore struct {
+	mu   sync.Mutex
+	keys map[string]*idempotentUpload
+}
+
+var idempotencyKeys = &idempotencyStore{keys: make(map[string]*idempotentUpload)}
+
+// begin claims key for an upload to path. A key that already completed for
+// the same path returns its record so the caller can replay the result.
+func (s *idempotencyStore) begin(key, path string) (*idempotentUpload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range s.keys {
+		if entry.result != nil && now.After(entry.expires) {
+			delete(s.keys, k)
+		}
+	}
+
+	if entry, ok := s.keys[key]; ok {
+		if entry.path != path {
+			return nil, errKeyReused
+		}
+		if entry.result == nil {
+			return nil, errKeyInFlight
+		}
+		return entry, nil
+	}
+	s.keys[key] = &idempotentUpload{path: path}
+	return nil, nil
+}
+
+// complete records the result of the upload holding key. The key stays tied
+// to the path given to begin, which differs from result.Path when the upload
+// was stored under another name.
+func (s *idempotencyStore) complete(key, uploadID string, result uploadResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := result.Path
+	if entry, ok := s.keys[key]; ok {
+		path = entry.path
+	}
+	s.keys[key] = &idempotentUpload{
+		path:     path,
+		uploadID: uploadID,
+		result:   &result,
+		expires:  time.Now().Add(idempotencyTTL),
+	}
+}
+
+// release forgets a key whose upload failed, so the client can retry it
+func (s *idempotencyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.keys[key]; ok && entry.result == nil {
+		delete(s.keys, key)
+	}
+}
+
+// newUploadID returns a random identifier for a completed upload
+func newUploadID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
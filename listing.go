@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileEntry describes a single directory entry in JSON listings
+type fileEntry struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	IsDir   bool   `json:"is_dir"`
+	ModTime string `json:"mod_time"`
+}
+
+// wantsJSON reports whether the client asked for a JSON response
+func wantsJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// writeJSONListing renders directory entries as a JSON array
+func writeJSONListing(w http.ResponseWriter, entries []os.DirEntry) {
+	files := make([]fileEntry, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// The entry vanished between ReadDir and Info, skip it
+			continue
+		}
+		files = append(files, fileEntry{
+			Name:    entry.Name(),
+			Size:    info.Size(),
+			IsDir:   entry.IsDir(),
+			ModTime: info.ModTime().Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+// Page used for HTML listings, nil unless -listing-template is set
+var listingTemplate *template.Template
+
+// listingPageData is what -listing-template is executed with
+type listingPageData struct {
+	Title       string // -title, empty when unset
+	Path        string
+	Parent      string // empty at the root
+	Breadcrumbs []breadcrumb
+	Entries     []listingEntry
+	Sort        string // name, size or time
+	Order       string // asc or desc
+	Page        int
+	Pages       int
+	PerPage     int
+	UploadForm  bool
+}
+
+// listingEntry is a file or directory as seen by -listing-template
+type listingEntry struct {
+	Name    string
+	URL     string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// loadListingTemplate parses -listing-template. Besides the usual builtins
+// templates can call humanize to format sizes like the built-in listing.
+func loadListingTemplate(file string) (*template.Template, error) {
+	return template.New(filepath.Base(file)).
+		Funcs(template.FuncMap{"humanize": humanizeBytes}).
+		ParseFiles(file)
+}
+
+// writeTemplateListing renders directory entries with -listing-template. It
+// reports false, having written nothing, when the template fails to execute.
+func writeTemplateListing(w http.ResponseWriter, r *http.Request, requestPath string, entries []os.DirEntry, sortBy string, desc bool, pg listingPage) bool {
+	data := listingPageData{
+		Title:       siteTitle,
+		Path:        r.URL.Path,
+		Breadcrumbs: breadcrumbTrail(requestPath),
+		Entries:     make([]listingEntry, 0, len(entries)),
+		Sort:        sortBy,
+		Order:       "asc",
+		Page:        pg.page,
+		Pages:       pg.pages,
+		PerPage:     pg.per,
+		UploadForm:  enableUploadForm && !readOnly,
+	}
+	if desc {
+		data.Order = "desc"
+	}
+	if requestPath != "/" {
+		parent := path.Dir(path.Clean(r.URL.Path))
+		if parent != "/" {
+			parent += "/"
+		}
+		data.Parent = (&url.URL{Path: parent}).String()
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// The entry vanished between ReadDir and Info, skip it
+			continue
+		}
+		link := path.Join(r.URL.Path, entry.Name())
+		if entry.IsDir() {
+			link += "/"
+		}
+		data.Entries = append(data.Entries, listingEntry{
+			Name:    entry.Name(),
+			URL:     (&url.URL{Path: link}).String(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   entry.IsDir(),
+		})
+	}
+
+	// Render into a buffer so a failing template can still fall back
+	var page bytes.Buffer
+	if err := listingTemplate.Execute(&page, data); err != nil {
+		logf(r, "Failed to render listing template: %v", err)
+		return false
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
+	return true
+}
+
+// writeHTMLListing renders directory entries as an HTML page, through
+// -listing-template when one is set
+func writeHTMLListing(w http.ResponseWriter, r *http.Request, requestPath string, entries []os.DirEntry, sortBy string, desc bool, pg listingPage) {
+	if listingTemplate != nil && writeTemplateListing(w, r, requestPath, entries, sortBy, desc, pg) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	heading := listingHeading(r.URL.Path)
+	fmt.Fprintf(w, "<html><head><title>%s</title><link rel=\"icon\" href=\"/favicon.ico\"></head><body>\n", heading)
+	fmt.Fprintf(w, "<h1>%s</h1>\n", heading)
+	fmt.Fprintf(w, "<p>%s</p>\n", breadcrumbs(requestPath))
+
+	// Column links toggle the order of the active column
+	fmt.Fprintf(w, "<p>Sort by: %s | %s | %s</p>\n",
+		sortLink("name", "Name", sortBy, desc),
+		sortLink("size", "Size", sortBy, desc),
+		sortLink("time", "Modified", sortBy, desc))
+	fmt.Fprintf(w, "<hr>\n<ul>\n")
+
+	// Add parent directory link if not at root
+	if requestPath != "/" {
+		parentPath := filepath.Dir(requestPath)
+		if parentPath == "." {
+			parentPath = "/"
+		}
+		parentLink := (&url.URL{Path: filepath.ToSlash(parentPath)}).String()
+		fmt.Fprintf(w, "<li><a href=\"%s\">../</a></li>\n", html.EscapeString(parentLink))
+	}
+
+	// List all entries
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		linkPath := filepath.Join(r.URL.Path, entry.Name())
+		linkPath = filepath.ToSlash(linkPath) // Convert to forward slashes for URLs
+		link := (&url.URL{Path: linkPath}).String()
+
+		// Directories show a dash since their size isn't meaningful
+		size, modified := "-", "-"
+		if info, err := entry.Info(); err == nil {
+			if !entry.IsDir() {
+				size = humanizeBytes(info.Size())
+			}
+			modified = info.ModTime().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a> %s %s</li>\n", html.EscapeString(link), html.EscapeString(name), size, modified)
+	}
+
+	fmt.Fprintf(w, "</ul>\n")
+
+	// Page links keep the current order and page size
+	if pg.pages > 1 {
+		order := "asc"
+		if desc {
+			order = "desc"
+		}
+		fmt.Fprintf(w, "<p>")
+		if pg.page > 1 {
+			fmt.Fprintf(w, "<a href=\"?sort=%s&amp;order=%s&amp;page=%d&amp;per=%d\">&laquo; Previous</a> ", sortBy, order, pg.page-1, pg.per)
+		}
+		fmt.Fprintf(w, "Page %d of %d", pg.page, pg.pages)
+		if pg.page < pg.pages {
+			fmt.Fprintf(w, " <a href=\"?sort=%s&amp;order=%s&amp;page=%d&amp;per=%d\">Next &raquo;</a>", sortBy, order, pg.page+1, pg.per)
+		}
+		fmt.Fprintf(w, "</p>\n")
+	}
+	fmt.Fprintf(w, "<hr>\n")
+
+	// Let browsers upload into the current directory
+	if enableUploadForm && !readOnly {
+		fmt.Fprintf(w, "<form method=\"POST\" action=\"%s\" enctype=\"multipart/form-data\">\n", html.EscapeString(r.URL.Path))
+		fmt.Fprintf(w, "<input type=\"file\" name=\"file\" multiple>\n<input type=\"submit\" value=\"Upload\">\n</form>\n")
+	}
+
+	fmt.Fprintf(w, "</body></html>\n")
+}
+
+// listingHeading is the HTML escaped page title of a listing, branded with
+// -title when one is set
+func listingHeading(urlPath string) string {
+	if siteTitle == "" {
+		return "Directory listing for " + html.EscapeString(urlPath)
+	}
+	return html.EscapeString(siteTitle + " - " + urlPath)
+}
+
+// breadcrumb is one segment of the path shown above a listing
+type breadcrumb struct {
+	Name string
+	URL  string // empty for the current directory
+}
+
+// breadcrumbTrail returns the root and every ancestor of a directory, e.g.
+// / > docs > api. The last segment is the current directory and has no URL.
+func breadcrumbTrail(requestPath string) []breadcrumb {
+	segments := strings.Split(strings.Trim(path.Clean("/"+requestPath), "/"), "/")
+	if segments[0] == "" {
+		return []breadcrumb{{Name: "/"}}
+	}
+
+	trail := []breadcrumb{{Name: "/", URL: "/"}}
+	href := ""
+	for i, segment := range segments {
+		href += "/" + segment
+		crumb := breadcrumb{Name: segment}
+		if i < len(segments)-1 {
+			crumb.URL = (&url.URL{Path: href + "/"}).String()
+		}
+		trail = append(trail, crumb)
+	}
+	return trail
+}
+
+// breadcrumbs renders the breadcrumb trail of a directory as HTML links
+func breadcrumbs(requestPath string) string {
+	var crumbs []string
+	for _, crumb := range breadcrumbTrail(requestPath) {
+		if crumb.URL == "" {
+			crumbs = append(crumbs, html.EscapeString(crumb.Name))
+			continue
+		}
+		crumbs = append(crumbs, fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(crumb.URL), html.EscapeString(crumb.Name)))
+	}
+	return strings.Join(crumbs, " &gt; ")
+}
+
+// listingSort reads the ?sort=name|size|time and ?order=asc|desc parameters
+func listingSort(r *http.Request) (sortBy string, desc bool) {
+	query := r.URL.Query()
+	sortBy = query.Get("sort")
+	if sortBy != "size" && sortBy != "time" {
+		sortBy = "name"
+	}
+	return sortBy, query.Get("order") == "desc"
+}
+
+// Entries per listing page unless ?per= asks for something else
+const defaultPageSize = 1000
+
+// listingPage is one page of a directory listing, page is 1-based
+type listingPage struct {
+	page, per, pages int
+}
+
+// parseListingPage reads the ?page=N and ?per=M parameters, falling back to
+// the first page of defaultPageSize entries
+func parseListingPage(r *http.Request) listingPage {
+	query := r.URL.Query()
+	pg := listingPage{page: 1, per: defaultPageSize, pages: 1}
+	if n, err := strconv.Atoi(query.Get("page")); err == nil && n > 0 {
+		pg.page = n
+	}
+	if n, err := strconv.Atoi(query.Get("per")); err == nil && n > 0 {
+		pg.per = n
+	}
+	return pg
+}
+
+// paginate returns the entries on the requested page. Pages past the end are
+// clamped to the last one, and pg is updated with the final page and count.
+func paginate(entries []os.DirEntry, pg *listingPage) []os.DirEntry {
+	pg.pages = max((len(entries)+pg.per-1)/pg.per, 1)
+	if pg.page > pg.pages {
+		pg.page = pg.pages
+	}
+	start := (pg.page - 1) * pg.per
+	end := min(start+pg.per, len(entries))
+	return entries[start:end]
+}
+
+// sortLink renders a column header link. Clicking the active column flips
+// its order, other columns start out ascending.
+func sortLink(column, label, sortBy string, desc bool) string {
+	order := "asc"
+	if column == sortBy {
+		if desc {
+			label += " &darr;"
+		} else {
+			label += " &uarr;"
+			order = "desc"
+		}
+	}
+	return fmt.Sprintf("<a href=\"?sort=%s&amp;order=%s\">%s</a>", column, order, label)
+}
+
+// sortEntries orders entries by name, size or modification time. os.ReadDir
+// already returns entries sorted by name, and the stable sort keeps that as
+// the tie-breaker for the other columns.
+func sortEntries(entries []os.DirEntry, sortBy string, desc bool) {
+	if sortBy == "name" {
+		if desc {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+		}
+		return
+	}
+
+	// Look each entry up once rather than on every comparison
+	infos := make(map[string]os.FileInfo, len(entries))
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil {
+			infos[entry.Name()] = info
+		}
+	}
+	compare := func(a, b os.FileInfo) int {
+		if a == nil || b == nil {
+			return 0
+		}
+		switch {
+		case sortBy == "size" && a.Size() != b.Size():
+			if a.Size() < b.Size() {
+				return -1
+			}
+			return 1
+		case sortBy == "time" && !a.ModTime().Equal(b.ModTime()):
+			if a.ModTime().Before(b.ModTime()) {
+				return -1
+			}
+			return 1
+		}
+		return 0
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		c := compare(infos[entries[i].Name()], infos[entries[j].Name()])
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+// humanizeBytes formats a byte count with binary units, e.g. 1.2 MiB
+func humanizeBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
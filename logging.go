@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// responseWriter records the status code and body size written by a handler
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.bytes += int64(n)
+	return n, err
+}
+
+// requestRecord is a single access log entry
+type requestRecord struct {
+	Time      string  `json:"time"`
+	RequestID string  `json:"request_id,omitempty"`
+	Remote    string  `json:"remote"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Status    int     `json:"status"`
+	BytesIn   int64   `json:"bytes_in"`
+	BytesOut  int64   `json:"bytes_out"`
+	Duration  float64 `json:"duration_ms"`
+}
+
+// logRequests writes one record per request once the handler has finished
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+
+		if verbose {
+			logf(r, "> %s %s %s", r.Method, r.URL.RequestURI(), r.Proto)
+			logf(r, "> Host: %s", r.Host)
+			logHeaders(r, ">", r.Header)
+		}
+
+		next.ServeHTTP(rw, r)
+
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if verbose {
+			logf(r, "< %d %s", status, http.StatusText(status))
+			logHeaders(r, "<", rw.Header())
+		}
+		record := requestRecord{
+			Time:      start.Format(time.RFC3339),
+			RequestID: requestID(r),
+			Remote:    clientIP(r),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    status,
+			BytesIn:   body.bytes,
+			BytesOut:  rw.bytes,
+			Duration:  float64(time.Since(start).Microseconds()) / 1000,
+		}
+		writeRecord(record)
+	})
+}
+
+// Headers whose values are credentials and never logged
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+}
+
+// logHeaders logs one line per header for -verbose, sorted by name, with
+// credentials redacted
+func logHeaders(r *http.Request, prefix string, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			if redactedHeaders[name] {
+				value = "[redacted]"
+			}
+			logf(r, "%s %s: %s", prefix, name, value)
+		}
+	}
+}
+
+// writeRecord prints a request record as text or, with -log-json, as a JSON
+// line. Records go to the -access-log file instead of stderr when one is set.
+func writeRecord(record requestRecord) {
+	if logJSON {
+		line, err := json.Marshal(record)
+		if err != nil {
+			log.Printf("Failed to encode request record: %v", err)
+			return
+		}
+		if accessLog != nil {
+			fmt.Fprintln(accessLog, string(line))
+			return
+		}
+		fmt.Fprintln(log.Writer(), string(line))
+		return
+	}
+
+	text := fmt.Sprintf("%s %s %s %d in=%d out=%d %.1fms",
+		record.Remote, record.Method, record.Path, record.Status,
+		record.BytesIn, record.BytesOut, record.Duration)
+	if record.RequestID != "" {
+		text = fmt.Sprintf("[%s] %s", record.RequestID, text)
+	}
+	if accessLog != nil {
+		fmt.Fprintf(accessLog, "%s %s\n", record.Time, text)
+		return
+	}
+	log.Print(text)
+}
@@ -1,80 +1,708 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
 	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/netutil"
 )
 
 var (
 	port      string
+	bindAddr  string
 	uploadDir string
+	authUser  string
+	authPass  string
+	maxSize   int64
+	certFile  string
+	keyFile   string
+
+	enableUploadForm bool
+	enableGzip       bool
+	logJSON          bool
+	indexFile        string
+	noIndex          bool
+	noListing        bool
+	patchCreate      bool
+	renderMarkdown   bool
+	highlightCode    bool
+	highlightMaxSize int64
+
+	dirMode  os.FileMode
+	fileMode os.FileMode
+
+	uploadRate  float64
+	uploadBurst int
+	trustProxy  bool
+	corsOrigin  string
+
+	uploadBandwidth   int64
+	downloadBandwidth int64
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+
+	maxDirFiles int
+	maxDirBytes int64
+
+	cacheSize    int64
+	cacheMaxFile int64
+
+	webhookURL    string
+	enableMetrics bool
+
+	searchMaxResults int
+	searchMaxDepth   int
+	treeMaxFiles     int
+	treeMaxDepth     int
+
+	readOnly    bool
+	dirRedirect bool
+
+	accessLogPath string
+	logMaxSize    int64
+
+	errorTemplatePath   string
+	listingTemplatePath string
+
+	fileTTL time.Duration
+
+	maxConcurrentUploads int
+	uploadQueueWait      time.Duration
+
+	siteTitle     string
+	hideStrict    bool
+	allowDotfiles bool
+
+	bufferSize int
+	maxDepth   int
+
+	verbose  bool
+	enableUI bool
+	casMode  bool
+	noMkdir  bool
+
+	rootRedirect    string
+	statsFile       string
+	storeCompressed bool
+	strict          bool
+	cacheControl    string
+	maxConns        int
+
+	noOverwriteRename bool
 )
 
 func main() {
 	// Parse command line arguments
 	flag.StringVar(&port, "h", "8000", "Server port")
+	flag.StringVar(&bindAddr, "bind", "", "Listen address such as 127.0.0.1:8000, overrides -h. A bare host listens on it at the -h port")
 	flag.StringVar(&uploadDir, "d", "/tmp/upload", "Upload directory")
+	flag.Var(&rootMaps, "map", "Serve a URL prefix from another directory as prefix=dir (repeatable)")
+	flag.StringVar(&authUser, "user", "", "Basic auth username (requires -pass)")
+	flag.StringVar(&authPass, "pass", "", "Basic auth password (requires -user)")
+	flag.Int64Var(&maxSize, "max-size", 0, "Maximum upload size in bytes (0 means unlimited)")
+	flag.StringVar(&certFile, "cert", "", "TLS certificate file (enables HTTPS together with -key)")
+	flag.StringVar(&keyFile, "key", "", "TLS private key file (enables HTTPS together with -cert)")
+	flag.BoolVar(&enableUploadForm, "enable-upload-form", true, "Show an upload form on directory listings")
+	flag.BoolVar(&enableGzip, "gzip", false, "Compress text responses for clients that accept gzip")
+	flag.BoolVar(&logJSON, "log-json", false, "Write request logs as JSON lines")
+	flag.StringVar(&indexFile, "index", "index.html", "File served in place of a directory listing when present")
+	flag.BoolVar(&noIndex, "no-index", false, "Always render directory listings, ignoring -index files")
+	flag.BoolVar(&noListing, "no-listing", false, "Disable directory listings, only files can be fetched")
+	flag.BoolVar(&patchCreate, "patch-create", false, "Let PATCH create missing files instead of returning 404")
+	flag.BoolVar(&renderMarkdown, "render-markdown", false, "Render .md and .markdown files as HTML (?raw=1 bypasses)")
+	flag.BoolVar(&highlightCode, "highlight", false, "Serve source code files as syntax highlighted HTML to browsers viewing them (?raw=1 bypasses, ?highlight=1 forces)")
+	flag.Int64Var(&highlightMaxSize, "highlight-max-size", 1<<20, "Largest file in bytes that -highlight will process")
+	flag.IntVar(&maxDirFiles, "max-files", 0, "Maximum number of files per directory (0 means unlimited)")
+	flag.Int64Var(&maxDirBytes, "max-dir-bytes", 0, "Maximum total size in bytes of the files in one directory (0 means unlimited)")
+	flag.Int64Var(&cacheSize, "cache-size", 0, "Memory in bytes for caching small files (0 disables the cache)")
+	flag.Int64Var(&cacheMaxFile, "cache-max-file", 256<<10, "Largest file in bytes kept in the -cache-size cache")
+	flag.StringVar(&webhookURL, "webhook-url", "", "URL to POST a JSON event to after each successful upload")
+	flag.BoolVar(&noOverwriteRename, "no-overwrite-rename", false, "Store uploads to an existing path under a timestamped name, e.g. report-20240101-120000.pdf, instead of overwriting")
+	flag.IntVar(&maxConns, "max-conns", 0, "Maximum number of simultaneous client connections, extra ones wait to be accepted (0 means unlimited)")
+	flag.StringVar(&cacheControl, "cache-control", "", "Cache-Control header sent with downloaded files, e.g. \"public, max-age=3600\"")
+	flag.BoolVar(&strict, "strict", false, "Refuse to start when an upload directory isn't writable instead of only warning")
+	flag.BoolVar(&storeCompressed, "store-compressed", false, "Store uploads sent with Content-Encoding: gzip as compressed bytes instead of decoding them")
+	flag.StringVar(&statsFile, "stats-file", "", "File the per-file download byte counts of /stats are kept in across restarts")
+	flag.StringVar(&rootRedirect, "root-redirect", "", "Redirect browsers requesting / to this path or URL instead of listing the root")
+	flag.BoolVar(&noMkdir, "no-mkdir", false, "Require the parent directory of an upload to exist instead of creating it")
+	flag.BoolVar(&casMode, "cas", false, "Store uploads once per distinct content under <dir>/.cas, resolving paths through a manifest")
+	flag.BoolVar(&enableUI, "ui", false, "Serve a browser file manager at /_ui/")
+	flag.BoolVar(&enableMetrics, "metrics", false, "Expose Prometheus metrics on /metrics")
+	flag.IntVar(&searchMaxResults, "search-max-results", 500, "Maximum number of matches returned by ?search=")
+	flag.IntVar(&searchMaxDepth, "search-max-depth", 10, "Maximum directory depth walked by ?search= (0 means unlimited)")
+	flag.IntVar(&treeMaxFiles, "tree-max-files", 10000, "Maximum number of files returned by ?recursive=1")
+	flag.IntVar(&treeMaxDepth, "tree-max-depth", 20, "Maximum directory depth walked by ?recursive=1 (0 means unlimited)")
+	flag.BoolVar(&readOnly, "readonly", false, "Reject every request that would modify files with 403 Forbidden")
+	flag.StringVar(&siteTitle, "title", "", "Site name shown in the title and heading of listing pages")
+	flag.Var(&hidePatterns, "hide", "Comma-separated glob patterns of names left out of listings")
+	flag.BoolVar(&allowDotfiles, "allow-dotfiles", false, "Serve and accept paths with a segment starting with a dot, such as .env or .git, which otherwise get 403 on every method")
+	flag.BoolVar(&hideStrict, "hide-strict", false, "Also answer 404 for direct requests to names matching -hide")
+	flag.BoolVar(&dirRedirect, "dir-redirect", true, "Redirect directory URLs without a trailing slash to the slashed URL")
+	flag.StringVar(&accessLogPath, "access-log", "", "Write request records to this file instead of stderr")
+	flag.Int64Var(&logMaxSize, "log-max-size", 100<<20, "Rotate the -access-log file to <file>.1 once it reaches this many bytes (0 disables rotation)")
+	flag.DurationVar(&fileTTL, "ttl", 0, "Delete files this long after their last modification, e.g. 24h (0 keeps files forever)")
+	flag.BoolVar(&verbose, "verbose", false, "Log extra detail: request and response headers (credentials redacted) and the old and new versions of overwritten files")
+	flag.IntVar(&maxDepth, "max-depth", 0, "Maximum number of segments in a request path (0 means unlimited)")
+	flag.IntVar(&bufferSize, "buffer-size", 32<<10, "Buffer size in bytes for copying upload and archive data")
+	flag.IntVar(&maxConcurrentUploads, "max-concurrent-uploads", 0, "Maximum number of uploads written at the same time (0 means unlimited)")
+	flag.DurationVar(&uploadQueueWait, "upload-queue", 0, "How long an upload waits for a free -max-concurrent-uploads slot before 503 (0 rejects at once)")
+	flag.Var(&extraHeaders, "header", "Extra \"Name: Value\" header sent with every response (repeatable)")
+	flag.Var(uploadRoutes, "route", "Store PUT uploads of a Content-Type under a subdirectory as type=subdir, e.g. image/*=images (repeatable)")
+	flag.Var(mimeOverrides, "mime", "Override the MIME type of an extension as ext=type, e.g. log=text/plain (repeatable)")
+	flag.Var(allowedExts, "allow-ext", "Comma-separated file extensions uploads are limited to, e.g. jpg,png,pdf (empty allows all)")
+	flag.Var(deniedExts, "deny-ext", "Comma-separated file extensions that are never accepted for upload (empty denies none)")
+	flag.StringVar(&errorTemplatePath, "error-template", "", "HTML template for error pages, given .Path, .Status, .StatusText and .Message")
+	flag.StringVar(&listingTemplatePath, "listing-template", "", "HTML template for directory listings, given .Path, .Breadcrumbs, .Entries and more")
+	flag.DurationVar(&readTimeout, "read-timeout", 60*time.Second, "Maximum time to read request headers, and the longest an upload may stall without sending data (0 disables)")
+	flag.DurationVar(&writeTimeout, "write-timeout", 0, "Maximum time to write a response (0 disables, keep it generous or off so large downloads aren't cut off)")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 120*time.Second, "How long idle keep-alive connections are kept open (0 disables)")
+	flag.StringVar(&corsOrigin, "cors-origin", "", "Allowed CORS origin, e.g. https://app.example.com or * (disabled when empty)")
+	flag.Float64Var(&uploadRate, "rate", 0, "Uploads allowed per second for each client IP (0 disables rate limiting)")
+	flag.IntVar(&uploadBurst, "burst", 5, "Maximum burst of uploads per client IP when -rate is set")
+	flag.Int64Var(&uploadBandwidth, "upload-rate", 0, "Maximum speed of each upload in bytes per second (0 means unlimited)")
+	flag.Int64Var(&downloadBandwidth, "download-rate", 0, "Maximum speed of each download in bytes per second (0 means unlimited)")
+	flag.BoolVar(&trustProxy, "trust-proxy", false, "Trust X-Forwarded-For and X-Forwarded-Proto for client addresses and URLs. Only enable behind a proxy that sets them, otherwise clients can spoof their address")
+	dirModeFlag := flag.String("dir-mode", "0755", "Permission mode (octal) for created directories")
+	fileModeFlag := flag.String("file-mode", "0644", "Permission mode (octal) for uploaded files")
 	flag.Parse()
 
+	// Validate permission modes before touching the filesystem
+	var err error
+	if dirMode, err = parseFileMode(*dirModeFlag); err != nil {
+		log.Fatalf("Invalid -dir-mode %q: %v", *dirModeFlag, err)
+	}
+	if fileMode, err = parseFileMode(*fileModeFlag); err != nil {
+		log.Fatalf("Invalid -file-mode %q: %v", *fileModeFlag, err)
+	}
+
+	if bufferSize <= 0 {
+		log.Fatalf("Invalid -buffer-size %d: must be positive", bufferSize)
+	}
+	if cacheSize > 0 {
+		contentCache = newFileCache(cacheSize)
+	}
+	if errorTemplatePath != "" {
+		if errorTemplate, err = template.ParseFiles(errorTemplatePath); err != nil {
+			log.Fatalf("Failed to load error template: %v", err)
+		}
+	}
+	if statsFile != "" {
+		if err := downloadStats.load(statsFile); err != nil {
+			log.Fatalf("Failed to load download stats: %v", err)
+		}
+	}
+	if listingTemplatePath != "" {
+		if listingTemplate, err = loadListingTemplate(listingTemplatePath); err != nil {
+			log.Fatalf("Failed to load listing template: %v", err)
+		}
+	}
+	if accessLogPath != "" {
+		if accessLog, err = openRotatingFile(accessLogPath, logMaxSize); err != nil {
+			log.Fatalf("Failed to open access log: %v", err)
+		}
+		defer accessLog.Close()
+		log.Printf("Writing access log to %s", accessLogPath)
+	}
+	if webhookURL != "" {
+		webhooks = newWebhookNotifier(webhookURL)
+		log.Printf("Sending upload events to %s", webhookURL)
+	}
+
+	// A regular file for -d means share just that file
+	singleFile := false
+	if info, err := os.Stat(uploadDir); err == nil && info.Mode().IsRegular() {
+		singleFile = true
+	}
+
 	// Create upload directory if it doesn't exist
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		log.Fatalf("Failed to create upload directory: %v", err)
+	if !singleFile {
+		if err := os.MkdirAll(uploadDir, dirMode); err != nil {
+			log.Fatalf("Failed to create upload directory: %v", err)
+		}
+	}
+	for _, m := range rootMaps {
+		if err := os.MkdirAll(m.dir, dirMode); err != nil {
+			log.Fatalf("Failed to create directory for %s: %v", m.prefix, err)
+		}
+		log.Printf("Serving %s from %s", m.prefix, m.dir)
+	}
+
+	// Catch a read-only -d or -map now rather than on the first upload
+	if !singleFile && !readOnly {
+		dirs := []string{uploadDir}
+		for _, m := range rootMaps {
+			dirs = append(dirs, m.dir)
+		}
+		for _, dir := range dirs {
+			if err := checkWritable(dir); err != nil {
+				if strict {
+					log.Fatalf("Upload directory %s is not writable: %v", dir, err)
+				}
+				log.Printf("Warning: upload directory %s is not writable, uploads will fail: %v", dir, err)
+			}
+		}
+	}
+
+	if maxConcurrentUploads > 0 {
+		uploadSlots = make(chan struct{}, maxConcurrentUploads)
+	}
+	if casMode {
+		if singleFile {
+			log.Fatalf("-cas needs -d to be a directory")
+		}
+		// Dedup never touches a stored object, so its age says nothing about
+		// whether a path still refers to it
+		if fileTTL > 0 {
+			log.Fatalf("-ttl can't be combined with -cas")
+		}
+		if casObjects, err = openCASStore(uploadDir); err != nil {
+			log.Fatalf("Failed to open content-addressable store: %v", err)
+		}
+		log.Printf("Content-addressable storage enabled in %s", casObjects.dir)
+	}
+	if fileTTL > 0 && !singleFile {
+		startExpirySweeper(fileTTL)
 	}
 
 	// Setup HTTP handlers
-	http.HandleFunc("/", handleRequest)
+	if readOnly {
+		log.Printf("Read-only mode, all writes are rejected")
+	}
+
+	var handler http.Handler = http.HandlerFunc(handleRequest)
+	if singleFile {
+		handler = http.HandlerFunc(handleSingleFile)
+		log.Printf("Serving single file %s on every path, uploads are disabled", uploadDir)
+	}
+	if authUser != "" && authPass != "" {
+		log.Printf("Basic auth enabled for user %s", authUser)
+	}
+	switch {
+	case !singleFile && casObjects == nil:
+		// .access files refine the Basic Auth policy per directory
+		handler = accessControl(handler)
+	case authUser != "" && authPass != "":
+		handler = basicAuth(handler)
+	}
+	if uploadRate > 0 {
+		handler = rateLimit(handler)
+	}
+	if errorTemplate != nil {
+		handler = errorPages(handler)
+	}
+	if enableMetrics {
+		handler = instrumentRequests(handler)
+	}
+	handler = requestIDs(logRequests(handler))
+	http.Handle("/", handler)
 
-	// Start server
-	addr := ":" + port
-	log.Printf("Starting file server on port %s, serving directory: %s", port, uploadDir)
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	// Liveness probe, registered separately so it skips auth and file handling
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/favicon.ico", handleFavicon)
+
+	// Upload progress streams, behind the same auth as uploads
+	var progressHandler http.Handler = http.HandlerFunc(handleProgress)
+	if authUser != "" && authPass != "" {
+		progressHandler = basicAuth(progressHandler)
+	}
+	http.Handle("/progress/", requestIDs(logRequests(progressHandler)))
+
+	// Download byte counts, behind the same auth as the files themselves
+	var statsHandler http.Handler = http.HandlerFunc(handleStats)
+	if authUser != "" && authPass != "" {
+		statsHandler = basicAuth(statsHandler)
+	}
+	http.Handle("/stats", requestIDs(logRequests(statsHandler)))
+
+	if enableUI {
+		var ui http.Handler = uiHandler()
+		if authUser != "" && authPass != "" {
+			ui = basicAuth(ui)
+		}
+		http.Handle("/_ui/", requestIDs(logRequests(ui)))
+		log.Printf("Web UI enabled on /_ui/")
+	}
+	if enableMetrics {
+		http.Handle("/metrics", metricsHandler())
+		log.Printf("Prometheus metrics enabled on /metrics")
+	}
+
+	// Extra headers and CORS apply to every route, not just file handling,
+	// so browser apps can also follow /progress/ and read /stats
+	var rootHandler http.Handler = http.DefaultServeMux
+	if corsOrigin != "" {
+		rootHandler = cors(rootHandler)
+	}
+	if len(extraHeaders) > 0 {
+		rootHandler = addHeaders(rootHandler)
+	}
+
+	server := &http.Server{
+		Addr:         listenAddress(),
+		Handler:      rootHandler,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+
+	// Stop on Ctrl-C or SIGTERM so in-flight transfers get a chance to finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start server, using TLS when both a certificate and key are given
+	errCh := make(chan error, 1)
+	go func() {
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		// Connections over the limit wait in the accept queue rather than being refused
+		if maxConns > 0 {
+			listener = netutil.LimitListener(listener, maxConns)
+			log.Printf("Limiting the server to %d simultaneous connections", maxConns)
+		}
+		if certFile != "" && keyFile != "" {
+			log.Printf("Starting HTTPS file server on %s, serving directory: %s", server.Addr, uploadDir)
+			errCh <- server.ServeTLS(listener, certFile, keyFile)
+		} else {
+			log.Printf("Starting HTTP file server on %s, serving directory: %s", server.Addr, uploadDir)
+			errCh <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	case <-ctx.Done():
+		log.Printf("Received signal, shutting down gracefully")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Graceful shutdown did not complete: %v", err)
+		}
+	}
+
+	if statsFile != "" {
+		if err := downloadStats.save(statsFile); err != nil {
+			log.Printf("Failed to save download stats: %v", err)
+		}
 	}
 }
 
+// checkWritable creates and removes a temp file in dir to prove uploads can
+// be stored there
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// listenAddress combines -bind and -h into the address to listen on
+func listenAddress() string {
+	if bindAddr == "" {
+		return ":" + port
+	}
+	if _, _, err := net.SplitHostPort(bindAddr); err != nil {
+		// No port given, so -bind is just the host
+		return net.JoinHostPort(strings.Trim(bindAddr, "[]"), port)
+	}
+	return bindAddr
+}
+
+// parseFileMode parses an octal permission string such as "0755"
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("not an octal number")
+	}
+	if mode > 0777 {
+		return 0, fmt.Errorf("only permission bits (0000-0777) are allowed")
+	}
+	return os.FileMode(mode), nil
+}
+
+// Methods advertised to OPTIONS and CORS preflight requests
+const allowedMethods = "GET, HEAD, PUT, POST, PATCH, DELETE, MOVE, COPY, PROPFIND, OPTIONS"
+
 func handleRequest(w http.ResponseWriter, r *http.Request) {
+	// Only reads get through in read-only mode
+	if readOnly && !isReadMethod(r.Method) {
+		http.Error(w, "Server is read-only", http.StatusForbidden)
+		return
+	}
+
+	// Guard against pathologically deep trees
+	if maxDepth > 0 && pathDepth(r.URL.Path) > maxDepth {
+		http.Error(w, fmt.Sprintf("Path exceeds the maximum depth of %d", maxDepth), http.StatusBadRequest)
+		return
+	}
+
+	// Dotfiles often hold credentials, don't let them be read or planted
+	if !ensureNoDotfiles(w, r.URL.Path) {
+		return
+	}
+
+	// Pace transfers so a single client can't saturate the link
+	throttleUpload(r)
+	w = throttleDownload(w, r)
+
+	// Send visitors of the bare root elsewhere, JSON clients and queries
+	// like ?download=zip still get the root itself
+	if rootRedirect != "" && isRootPage(r) {
+		http.Redirect(w, r, rootRedirect, http.StatusFound)
+		return
+	}
+
+	if casObjects != nil {
+		handleCAS(w, r)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		handleGet(w, r)
+	case http.MethodHead:
+		handleHead(w, r)
 	case http.MethodPut:
 		handlePut(w, r)
+	case http.MethodPost:
+		handlePost(w, r)
+	case http.MethodPatch:
+		handlePatch(w, r)
+	case http.MethodDelete:
+		handleDelete(w, r)
+	case http.MethodOptions:
+		handleOptions(w, r)
+	case "MOVE":
+		handleMove(w, r)
+	case "COPY":
+		handleCopy(w, r)
+	case "PROPFIND":
+		handlePropfind(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-// Handle GET requests - list files in directory
-func handleGet(w http.ResponseWriter, r *http.Request) {
+// Methods allowed with -readonly
+const readOnlyMethods = "GET, HEAD, PROPFIND, OPTIONS"
+
+// Methods allowed when -d names a single file
+const singleFileMethods = "GET, HEAD, OPTIONS"
+
+// isRootPage reports whether r is a plain browser request for /
+func isRootPage(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		r.URL.Path == "/" && r.URL.RawQuery == "" && !wantsJSON(r)
+}
+
+// isReadMethod reports whether method never modifies files
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions || method == "PROPFIND"
+}
+
+// Handle requests when -d names a single file - every path serves that file
+// and anything that would modify it is refused
+func handleSingleFile(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		info, err := os.Stat(uploadDir)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error accessing file: %v", err), http.StatusInternalServerError)
+			return
+		}
+		serveFile(w, r, uploadDir, info)
+	case http.MethodOptions:
+		w.Header().Set("Allow", singleFileMethods)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", singleFileMethods)
+		http.Error(w, "Method not allowed, serving a single file", http.StatusMethodNotAllowed)
+	}
+}
+
+// statRequestPath resolves the request path under its root directory and stats it.
+// On failure an error response is written and ok is false.
+func statRequestPath(w http.ResponseWriter, r *http.Request) (requestPath, fullPath string, info os.FileInfo, ok bool) {
 	// Clean the path to prevent directory traversal attacks
-	requestPath := filepath.Clean(r.URL.Path)
+	requestPath = filepath.Clean(r.URL.Path)
 	if requestPath == "." {
 		requestPath = "/"
 	}
 	
-	// Build the full path
-	fullPath := filepath.Join(uploadDir, requestPath)
+	// With -hide-strict, hidden names can't be fetched either
+	if hideStrict && isHiddenPath(requestPath) {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return "", "", nil, false
+	}
+
+	// Build the full path under the root this path maps to
+	root, fullPath := fsPath(requestPath)
+
+	// Refuse symlinks that lead outside the upload directory
+	if !ensureWithinRoot(w, root, fullPath) {
+		return "", "", nil, false
+	}
 
 	// Check if path exists
 	info, err := os.Stat(fullPath)
 	if os.IsNotExist(err) {
 		http.Error(w, "Path not found", http.StatusNotFound)
-		return
+		return "", "", nil, false
 	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error accessing path: %v", err), http.StatusInternalServerError)
+		return "", "", nil, false
+	}
+
+	return requestPath, fullPath, info, true
+}
+
+// ensureParentDir creates dir and any missing parents, or with -no-mkdir
+// insists that it already exists. On failure an error response is written
+// and false is returned.
+func ensureParentDir(w http.ResponseWriter, dir string) bool {
+	if !noMkdir {
+		if err := os.MkdirAll(dir, dirMode); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create directory: %v", err), http.StatusInternalServerError)
+			return false
+		}
+		return true
+	}
+
+	info, err := os.Stat(dir)
+	switch {
+	case err == nil && info.IsDir():
+		return true
+	case err == nil:
+		http.Error(w, "Parent path is not a directory", http.StatusConflict)
+	case os.IsNotExist(err):
+		http.Error(w, "Parent directory does not exist", http.StatusConflict)
+	default:
+		http.Error(w, fmt.Sprintf("Error accessing directory: %v", err), http.StatusInternalServerError)
+	}
+	return false
+}
+
+// Handle OPTIONS requests - advertise the supported methods
+func handleOptions(w http.ResponseWriter, r *http.Request) {
+	// Lets WebDAV clients know PROPFIND is understood
+	w.Header().Set("DAV", "1")
+	if readOnly {
+		w.Header().Set("Allow", readOnlyMethods)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Allow", allowedMethods)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handle health checks - report liveness without touching the filesystem
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, "ok")
+}
+
+// Handle GET requests - list files in directory
+func handleGet(w http.ResponseWriter, r *http.Request) {
+	// Resumable upload clients ask how far they got, the file may not exist yet
+	if r.URL.Query().Get("resume") == "status" {
+		handleResumeStatus(w, r)
+		return
+	}
+
+	requestPath, fullPath, info, ok := statRequestPath(w, r)
+	if !ok {
 		return
 	}
 
 	// If it's a file, serve the file
 	if !info.IsDir() {
-		serveFile(w, r, fullPath)
+		serveFile(w, r, fullPath, info)
+		return
+	}
+
+	// Download the whole directory as an archive or flat tree, unless browsing is disabled
+	if !noListing {
+		switch r.URL.Query().Get("download") {
+		case "zip":
+			serveZip(w, r, fullPath)
+			return
+		case "tar.gz":
+			serveTarGz(w, r, fullPath)
+			return
+		}
+
+		// The whole tree as flat JSON, for sync clients
+		if r.URL.Query().Get("recursive") == "1" {
+			serveTree(w, r, fullPath)
+			return
+		}
+	}
+
+	// Relative links in listings and index pages resolve against the parent
+	// unless the URL ends in a slash, so send browsers to the slashed URL
+	if dirRedirect && !strings.HasSuffix(r.URL.Path, "/") && !wantsJSON(r) {
+		target := url.URL{Path: r.URL.Path + "/", RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+		return
+	}
+
+	// Serve the directory's index page in place of a generated listing
+	if !noIndex && indexFile != "" && !wantsJSON(r) {
+		indexPath := filepath.Join(fullPath, indexFile)
+		indexInfo, err := os.Stat(indexPath)
+		root, _ := fsPath(requestPath)
+		if err == nil && !indexInfo.IsDir() && checkWithinRoot(root, indexPath) == nil {
+			serveFile(w, r, indexPath, indexInfo)
+			return
+		}
+	}
+
+	// Only direct file fetches are allowed when listings are disabled
+	if noListing {
+		http.Error(w, "Directory listing is disabled", http.StatusForbidden)
+		return
+	}
+
+	// Look for matching names anywhere below this directory
+	if term := r.URL.Query().Get("search"); term != "" {
+		serveSearch(w, r, fullPath, term)
 		return
 	}
 
@@ -85,39 +713,114 @@ func handleGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	fmt.Fprintf(w, "<html><head><title>Directory listing for %s</title></head><body>\n", r.URL.Path)
-	fmt.Fprintf(w, "<h1>Directory listing for %s</h1>\n", r.URL.Path)
-	fmt.Fprintf(w, "<hr>\n<ul>\n")
+	serveListing(w, r, requestPath, entries)
+}
+
+// serveListing renders directory entries as JSON or HTML, after dropping
+// hidden names and applying the requested order and page
+func serveListing(w http.ResponseWriter, r *http.Request, requestPath string, entries []os.DirEntry) {
+	entries = filterHidden(entries)
 
-	// Add parent directory link if not at root
-	if requestPath != "/" {
-		parentPath := filepath.Dir(requestPath)
-		if parentPath == "." {
-			parentPath = "/"
-		}
-		fmt.Fprintf(w, "<li><a href=\"%s\">../</a></li>\n", parentPath)
+	// Order entries as requested, name ascending by default
+	sortBy, desc := listingSort(r)
+	sortEntries(entries, sortBy, desc)
+
+	// Keep huge directories manageable. JSON clients get every entry unless
+	// they ask for a page.
+	pg := parseListingPage(r)
+	query := r.URL.Query()
+	if !wantsJSON(r) || query.Has("page") || query.Has("per") {
+		entries = paginate(entries, &pg)
 	}
 
-	// List all entries
-	for _, entry := range entries {
-		name := entry.Name()
-		if entry.IsDir() {
-			name += "/"
-		}
-		linkPath := filepath.Join(r.URL.Path, entry.Name())
-		linkPath = filepath.ToSlash(linkPath) // Convert to forward slashes for URLs
-		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a></li>\n", linkPath, name)
+	if wantsJSON(r) {
+		w, closeGzip := maybeGzip(w, r, "application/json")
+		defer closeGzip()
+		writeJSONListing(w, entries)
+		return
+	}
+
+	w, closeGzip := maybeGzip(w, r, "text/html")
+	defer closeGzip()
+	writeHTMLListing(w, r, requestPath, entries, sortBy, desc, pg)
+}
+
+// Handle HEAD requests - report file metadata without a body
+func handleHead(w http.ResponseWriter, r *http.Request) {
+	_, fullPath, info, ok := statRequestPath(w, r)
+	if !ok {
+		return
+	}
+
+	if info.IsDir() {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		return
 	}
 
-	fmt.Fprintf(w, "</ul>\n<hr>\n</body></html>\n")
+	mimeType := fileContentType(fullPath)
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	w.Header().Set("ETag", fileETag(info))
+	w.WriteHeader(http.StatusOK)
 }
 
 // serveFile serves a file with appropriate headers based on file type
-func serveFile(w http.ResponseWriter, r *http.Request, filePath string) {
-	// Get the MIME type based on file extension
-	ext := filepath.Ext(filePath)
-	mimeType := mime.TypeByExtension(ext)
+func serveFile(w http.ResponseWriter, r *http.Request, filePath string, info os.FileInfo) {
+	// Let clients revalidate cached copies without downloading them again
+	etag := fileETag(info)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	// If-Modified-Since only counts when there's no If-None-Match
+	if r.Header.Get("If-None-Match") == "" && notModifiedSince(r.Header.Get("If-Modified-Since"), info.ModTime()) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Count what is actually sent, after compression, for /stats
+	var finishCount func()
+	w, finishCount = countTransfer(w, statsKey(r, filePath, info))
+	defer finishCount()
+
+	// Optional integrity header, hashing is cached but the first request for a large file is slow
+	if r.URL.Query().Get("checksum") == "1" {
+		digest, err := fileSHA256(filePath, info)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error computing checksum: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Checksum-SHA256", digest)
+	}
+
+	// Render markdown as HTML unless the raw file was asked for
+	if renderMarkdown && isMarkdownFile(filePath) && r.URL.Query().Get("raw") != "1" {
+		serveMarkdown(w, r, filePath)
+		return
+	}
+
+	// Highlight source code for browsers viewing it, unless it's too large to do quickly
+	if highlightCode && isSourceFile(filePath) && info.Size() <= highlightMaxSize {
+		// The same URL is a page or a raw asset depending on Accept
+		w.Header().Add("Vary", "Accept")
+		if wantsHighlight(r) {
+			serveHighlighted(w, r, filePath)
+			return
+		}
+	}
+
+	// Get the MIME type based on file extension, or the content if that's unknown
+	mimeType := fileContentType(filePath)
 	
 	// Determine if the file is a text file
 	isTextFile := isTextMimeType(mimeType)
@@ -127,22 +830,126 @@ func serveFile(w http.ResponseWriter, r *http.Request, filePath string) {
 		if mimeType != "" {
 			w.Header().Set("Content-Type", mimeType)
 		}
-		log.Printf("Serving text file for viewing: %s (type: %s)", filePath, mimeType)
+		logf(r, "Serving text file for viewing: %s (type: %s)", filePath, mimeType)
 	} else {
-		// Non-text files: force download
+		// Non-text files: force download, unless ?inline=1 asks the browser
+		// to preview it (images, PDFs)
+		disposition := "attachment"
+		if r.URL.Query().Get("inline") == "1" {
+			disposition = "inline"
+		}
 		fileName := filepath.Base(filePath)
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, fileName))
 		if mimeType != "" {
 			w.Header().Set("Content-Type", mimeType)
 		} else {
 			w.Header().Set("Content-Type", "application/octet-stream")
 		}
-		log.Printf("Serving file for download: %s (type: %s)", filePath, mimeType)
+		logf(r, "Serving file for download: %s (type: %s)", filePath, mimeType)
 	}
 	
+	// Assets compressed at build time beat compressing on the fly
+	if servePrecompressed(w, r, filePath, info) {
+		return
+	}
+
+	// Compress text files, except for partial requests where byte offsets
+	// must refer to the original content
+	if isTextFile && r.Header.Get("Range") == "" {
+		var closeGzip func()
+		w, closeGzip = maybeGzip(w, r, mimeType)
+		defer closeGzip()
+	}
+
+	// All headers above must be set before http.ServeFile writes the response.
+	// It keeps an existing Content-Type and handles Range and If-Range itself,
+	// replying 206 Partial Content with a matching Content-Range. It also sets
+	// Content-Length once preconditions have passed, so download managers can
+	// show progress without redirects and 412s claiming a body. Small files
+	// held by the read cache go through http.ServeContent the same way.
+	if data, ok := cachedContent(r, filePath, info); ok {
+		http.ServeContent(w, r, filePath, info.ModTime(), bytes.NewReader(data))
+		return
+	}
 	http.ServeFile(w, r, filePath)
 }
 
+// fileETag builds a weak ETag from the file size and modification time
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`W/"%d-%d"`, info.Size(), info.ModTime().Unix())
+}
+
+// ifMatchHolds reports whether an If-Match header is satisfied by the file
+// at fullPath. An absent header always holds, and any other value needs an
+// existing regular file whose ETag it matches.
+func ifMatchHolds(header, fullPath string) bool {
+	if header == "" {
+		return true
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	return etagMatches(header, fileETag(info))
+}
+
+// etagMatches reports whether an If-None-Match style header value matches etag.
+// Comparison is weak, so W/ prefixes are ignored on both sides.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// fileContentType returns the MIME type for a file's extension, preferring
+// any -mime override over the system table. Files with no known extension
+// are sniffed from their first 512 bytes instead. The sniffing uses its own
+// handle, so the file is always served from offset zero.
+// An empty string means the type couldn't be determined.
+func fileContentType(filePath string) string {
+	if mimeType := mimeOverrides.lookup(filePath); mimeType != "" {
+		return mimeType
+	}
+	if mimeType := mime.TypeByExtension(filepath.Ext(filePath)); mimeType != "" {
+		return mimeType
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return ""
+	}
+	return http.DetectContentType(buf[:n])
+}
+
+// notModifiedSince reports whether a file modified at modTime is unchanged
+// since the If-Modified-Since header value. HTTP dates have one second
+// resolution, so modTime is truncated before comparing.
+func notModifiedSince(header string, modTime time.Time) bool {
+	if header == "" {
+		return false
+	}
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(since)
+}
+
 // isTextMimeType checks if a MIME type represents a text file
 func isTextMimeType(mimeType string) bool {
 	if mimeType == "" {
@@ -169,6 +976,15 @@ func isTextMimeType(mimeType string) bool {
 
 // Handle PUT requests - upload files
 func handlePut(w http.ResponseWriter, r *http.Request) {
+	// Bound the number of uploads hitting the disk at once
+	if !acquireUploadSlot(w, r) {
+		return
+	}
+	defer releaseUploadSlot()
+
+	// Keep slow but steady uploads alive past -read-timeout
+	extendReadDeadline(w, r)
+
 	// Clean the path to prevent directory traversal attacks
 	requestPath := filepath.Clean(r.URL.Path)
 	if requestPath == "/" || requestPath == "." {
@@ -178,33 +994,539 @@ func handlePut(w http.ResponseWriter, r *http.Request) {
 
 	// Remove leading slash for filepath.Join
 	requestPath = strings.TrimPrefix(requestPath, "/")
+
+	// Sort file uploads into the -route subdirectory for their Content-Type
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		var ok bool
+		if requestPath, ok = routeUpload(w, r, requestPath); !ok {
+			return
+		}
+	}
+	if !ensureNameLengths(w, requestPath) {
+		return
+	}
 	
-	// Build the full path
-	fullPath := filepath.Join(uploadDir, requestPath)
+	// Build the full path under the root this path maps to
+	root, fullPath := fsPath(requestPath)
+	if fullPath == root {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+
+	// Refuse symlinks that lead outside the upload directory
+	if !ensureWithinRoot(w, root, fullPath) {
+		return
+	}
+
+	// A trailing slash asks for a directory rather than a file
+	if strings.HasSuffix(r.URL.Path, "/") {
+		createDirectory(w, r, fullPath, requestPath)
+		return
+	}
+
+	if !ensureAllowedExtension(w, fullPath) {
+		return
+	}
 
 	// Create parent directories if they don't exist
 	parentDir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(parentDir, 0755); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create directory: %v", err), http.StatusInternalServerError)
+	if !ensureParentDir(w, parentDir) {
 		return
 	}
 
-	// Create the file
-	file, err := os.Create(fullPath)
+	// Enforce the maximum upload size, rejecting early when the length is known
+	if maxSize > 0 {
+		if r.ContentLength > maxSize {
+			http.Error(w, fmt.Sprintf("File exceeds maximum upload size of %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+
+	// Resumable uploads send the file in chunks at explicit offsets
+	if r.Header.Get("Content-Range") != "" {
+		handleChunk(w, r, fullPath, requestPath)
+		return
+	}
+
+	// A retried PUT with a known X-Idempotency-Key gets the original result
+	// back instead of writing the file again
+	idempotencyKey := r.Header.Get("X-Idempotency-Key")
+	if idempotencyKey != "" {
+		previous, err := idempotencyKeys.begin(idempotencyKey, "/"+requestPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if previous != nil {
+			logf(r, "Replaying upload %s for idempotency key %q", previous.uploadID, idempotencyKey)
+			w.Header().Set("X-Upload-Id", previous.uploadID)
+			writeUploadJSON(w, http.StatusOK, *previous.result)
+			return
+		}
+		defer idempotencyKeys.release(idempotencyKey)
+	}
+
+	// Keep the target directory within its quota. Chunked bodies have no length
+	// up front and are checked while copying instead.
+	if !ensureQuota(w, fullPath, max(r.ContentLength, 0)) {
+		return
+	}
+
+	// "If-None-Match: *" asks for a create-only upload. Fail fast here, the
+	// final link into place below enforces it atomically.
+	createOnly := r.Header.Get("If-None-Match") == "*"
+	if _, err := os.Lstat(fullPath); createOnly && err == nil {
+		http.Error(w, "File already exists", http.StatusPreconditionFailed)
+		return
+	}
+
+	// "If-Match: <etag>" only replaces the version the client last saw
+	ifMatch := r.Header.Get("If-Match")
+	if !ifMatchHolds(ifMatch, fullPath) {
+		http.Error(w, "File has changed, ETag does not match", http.StatusPreconditionFailed)
+		return
+	}
+
+	// Write to a temp file in the target directory and only move it into
+	// place once complete, so readers see either the old or the new file
+	file, err := os.CreateTemp(parentDir, ".upload-*")
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create file: %v", err), http.StatusInternalServerError)
 		return
 	}
-	defer file.Close()
+	tmpPath := file.Name()
+	committed := false
+	defer func() {
+		file.Close()
+		if !committed {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	// Hash the body on the way through when the client sent Content-MD5
+	body := io.Reader(r.Body)
+	expectedMD5 := r.Header.Get("Content-MD5")
+	md5Hash := md5.New()
+	if expectedMD5 != "" {
+		body = io.TeeReader(body, md5Hash)
+	}
+
+	// Publish progress to /progress/<X-Upload-Id> streams
+	body, progressDone := trackProgress(r, body)
+	defer func() { progressDone(committed) }()
+
+	// A body sent with Content-Encoding: gzip is stored as the original file.
+	// Content-MD5 and progress above refer to the bytes as sent.
+	gzipped := gzipEncoded(r)
+	if gzipped {
+		var ok bool
+		if body, ok = decodeGzipBody(w, body); !ok {
+			return
+		}
+	}
+
+	// JSON clients get a SHA-256 of what was stored, and it's kept for
+	// replaying idempotent requests
+	jsonResponse := wantsJSON(r)
+	sha256Hash := sha256.New()
+	if jsonResponse || idempotencyKey != "" {
+		body = io.TeeReader(body, sha256Hash)
+	}
+
+	// Hold the stored bytes to the directory quota as they arrive
+	if body, err = limitToQuota(body, fullPath, 0); err != nil {
+		http.Error(w, fmt.Sprintf("Error checking quota: %v", err), http.StatusInternalServerError)
+		return
+	}
 
 	// Copy the uploaded data to the file
-	written, err := io.Copy(file, r.Body)
+	written, err := copyBuffered(file, body)
 	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, fmt.Sprintf("File exceeds maximum upload size of %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, errQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+		if isTimeout(err) {
+			http.Error(w, "Upload stalled, request timed out", http.StatusRequestTimeout)
+			return
+		}
+		if gzipped && isCorruptGzip(err) {
+			http.Error(w, fmt.Sprintf("Corrupt gzip body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if diskFull(w, r, err) {
+			return
+		}
 		http.Error(w, fmt.Sprintf("Failed to write file: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Uploaded file: %s (%d bytes)", fullPath, written)
+	// Reject corrupted uploads
+	if expectedMD5 != "" {
+		actualMD5 := base64.StdEncoding.EncodeToString(md5Hash.Sum(nil))
+		if actualMD5 != expectedMD5 {
+			http.Error(w, fmt.Sprintf("Content-MD5 mismatch: expected %s, computed %s", expectedMD5, actualMD5), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Temp files are created 0600, apply the configured mode before publishing
+	if err := file.Chmod(fileMode); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to set file mode: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := file.Close(); err != nil {
+		if diskFull(w, r, err) {
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to write file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// Check again, another client may have replaced the file during the upload
+	if !ifMatchHolds(ifMatch, fullPath) {
+		http.Error(w, "File has changed, ETag does not match", http.StatusPreconditionFailed)
+		return
+	}
+	stored, previous, err := placeUpload(tmpPath, fullPath, createOnly, ifMatch)
+	if err != nil {
+		if os.IsExist(err) {
+			http.Error(w, "File already exists", http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to store file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	committed = true
+	if stored != fullPath {
+		logf(r, "Keeping existing file %s, storing the upload as %s", fullPath, stored)
+		fullPath = stored
+		requestPath = path.Join(path.Dir(requestPath), filepath.Base(stored))
+	}
+	invalidateUsage(parentDir)
+
+	logf(r, "Uploaded file: %s (%d bytes)", fullPath, written)
+	if verbose && previous != nil {
+		logOverwrite(r, fullPath, previous)
+	}
+	notifyUpload(r, requestPath, written)
+	result := newUploadResult(requestPath, written, hex.EncodeToString(sha256Hash.Sum(nil)))
+	if idempotencyKey != "" {
+		uploadID := r.Header.Get("X-Upload-Id")
+		if uploadID == "" {
+			uploadID = newUploadID()
+		}
+		idempotencyKeys.complete(idempotencyKey, uploadID, result)
+		w.Header().Set("X-Upload-Id", uploadID)
+	}
+
+	// 201 for a new file, 200 when an existing one was replaced
+	status, message := http.StatusCreated, "File uploaded successfully"
+	if previous != nil {
+		status, message = http.StatusOK, "File replaced successfully"
+	} else {
+		w.Header().Set("Location", absoluteURL(r, requestPath))
+	}
+	if jsonResponse {
+		writeUploadJSON(w, status, result)
+		return
+	}
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s: %s (%d bytes)\n", message, requestPath, written)
+}
+
+// logOverwrite records the versions before and after an upload replaced a file
+func logOverwrite(r *http.Request, fullPath string, previous os.FileInfo) {
+	current, err := os.Stat(fullPath)
+	if err != nil {
+		return
+	}
+	logf(r, "Overwrote file: %s (was %d bytes, modified %s; now %d bytes, modified %s)",
+		fullPath, previous.Size(), previous.ModTime().Format(time.RFC3339Nano),
+		current.Size(), current.ModTime().Format(time.RFC3339Nano))
+}
+
+// createDirectory handles a PUT to a path ending in a slash by creating the
+// directory and any missing parents. Such requests must not carry a body.
+func createDirectory(w http.ResponseWriter, r *http.Request, fullPath, requestPath string) {
+	var probe [1]byte
+	if n, _ := io.ReadFull(r.Body, probe[:]); n > 0 {
+		http.Error(w, "Directory creation takes no request body", http.StatusBadRequest)
+		return
+	}
+
+	info, err := os.Stat(fullPath)
+	if err == nil && !info.IsDir() {
+		http.Error(w, "A file already exists at this path", http.StatusConflict)
+		return
+	}
+	existed := err == nil
+
+	if !existed && !ensureParentDir(w, filepath.Dir(fullPath)) {
+		return
+	}
+	if err := os.MkdirAll(fullPath, dirMode); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create directory: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if existed {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "Directory already exists: %s/\n", requestPath)
+		return
+	}
+
+	logf(r, "Created directory: %s", fullPath)
+	w.Header().Set("Location", absoluteURL(r, requestPath+"/"))
 	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "File uploaded successfully: %s (%d bytes)\n", requestPath, written)
+	fmt.Fprintf(w, "Directory created: %s/\n", requestPath)
+}
+
+// uploadResult is the JSON body returned for a completed upload
+type uploadResult struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	CreatedAt string `json:"created_at"`
+}
+
+func newUploadResult(requestPath string, size int64, digest string) uploadResult {
+	return uploadResult{
+		Path:      "/" + strings.TrimPrefix(requestPath, "/"),
+		Size:      size,
+		SHA256:    digest,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+}
+
+// writeUploadJSON replies with the metadata of a stored upload
+func writeUploadJSON(w http.ResponseWriter, status int, result uploadResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// commitUpload moves a completed temp file to its final path. Create-only
+// uploads use a hard link, which fails if the target appeared meanwhile,
+// instead of a rename that would silently replace it.
+func commitUpload(tmpPath, fullPath string, createOnly bool) error {
+	if !createOnly {
+		return os.Rename(tmpPath, fullPath)
+	}
+	if err := os.Link(tmpPath, fullPath); err != nil {
+		return err
+	}
+	return os.Remove(tmpPath)
+}
+
+// placeUpload moves a finished upload from tmpPath to fullPath. With
+// -no-overwrite-rename an existing file is kept and the upload stored under a
+// timestamped name instead, unless the client asked for a create-only upload
+// or to replace a specific version with If-Match. It returns the path the
+// upload was stored at and the file it replaced, nil when there was none.
+func placeUpload(tmpPath, fullPath string, createOnly bool, ifMatch string) (stored string, previous os.FileInfo, err error) {
+	if noOverwriteRename && !createOnly && ifMatch == "" {
+		stored, err = commitRenamed(tmpPath, fullPath, time.Now())
+		return stored, nil, err
+	}
+	// What the rename replaces, for the overwrite audit trail
+	previous, _ = os.Lstat(fullPath)
+	return fullPath, previous, commitUpload(tmpPath, fullPath, createOnly)
+}
+
+// Attempts at finding a free timestamped name before giving up
+const maxRenameAttempts = 100
+
+// commitRenamed moves a finished upload to fullPath like commitUpload, but
+// never replaces a file. When fullPath is taken the upload gets a name with
+// its time instead, such as report-20240101-120000.pdf, with a counter added
+// if even that exists. It returns the path the upload was stored at.
+func commitRenamed(tmpPath, fullPath string, now time.Time) (string, error) {
+	err := commitUpload(tmpPath, fullPath, true)
+	if !os.IsExist(err) {
+		return fullPath, err
+	}
+
+	ext := filepath.Ext(fullPath)
+	base := strings.TrimSuffix(fullPath, ext) + "-" + now.Format("20060102-150405")
+	for n := 1; n <= maxRenameAttempts; n++ {
+		candidate := base + ext
+		if n > 1 {
+			candidate = fmt.Sprintf("%s-%d%s", base, n, ext)
+		}
+		err := commitUpload(tmpPath, candidate, true)
+		if !os.IsExist(err) {
+			return candidate, err
+		}
+	}
+	return "", fmt.Errorf("no free name for %s after %d attempts", filepath.Base(fullPath), maxRenameAttempts)
+}
+
+// Handle PATCH requests - append the body to a file
+func handlePatch(w http.ResponseWriter, r *http.Request) {
+	// Keep slow but steady uploads alive past -read-timeout
+	extendReadDeadline(w, r)
+
+	// Clean the path to prevent directory traversal attacks
+	requestPath := filepath.Clean(r.URL.Path)
+	if requestPath == "/" || requestPath == "." {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+
+	// Remove leading slash for filepath.Join
+	requestPath = strings.TrimPrefix(requestPath, "/")
+
+	// Build the full path under the root this path maps to
+	root, fullPath := fsPath(requestPath)
+	if fullPath == root {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+
+	// Refuse symlinks that lead outside the upload directory
+	if !ensureWithinRoot(w, root, fullPath) {
+		return
+	}
+
+	// Appending needs an existing file unless -patch-create is set
+	flags := os.O_APPEND | os.O_WRONLY
+	info, err := os.Stat(fullPath)
+	switch {
+	case os.IsNotExist(err) && !patchCreate:
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	case os.IsNotExist(err):
+		if !ensureAllowedExtension(w, fullPath) || !ensureParentDir(w, filepath.Dir(fullPath)) {
+			return
+		}
+		flags |= os.O_CREATE
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Error accessing path: %v", err), http.StatusInternalServerError)
+		return
+	case info.IsDir():
+		http.Error(w, "Path is a directory", http.StatusConflict)
+		return
+	}
+
+	if maxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+
+	// The file grows by the body length, when it is known
+	currentSize := int64(0)
+	if info != nil {
+		currentSize = info.Size()
+	}
+	if !ensureQuota(w, fullPath, currentSize+max(r.ContentLength, 0)) {
+		return
+	}
+
+	file, err := os.OpenFile(fullPath, flags, fileMode)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	body, err := limitToQuota(r.Body, fullPath, currentSize)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error checking quota: %v", err), http.StatusInternalServerError)
+		return
+	}
+	written, err := copyBuffered(file, body)
+	if err != nil {
+		// Don't leave a partial append behind, or a file PATCH just created
+		if info == nil {
+			os.Remove(fullPath)
+		} else {
+			file.Truncate(currentSize)
+		}
+
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, fmt.Sprintf("Append exceeds maximum upload size of %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, errQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+		if isTimeout(err) {
+			http.Error(w, "Upload stalled, request timed out", http.StatusRequestTimeout)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to append to file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	invalidateUsage(filepath.Dir(fullPath))
+	logf(r, "Appended to file: %s (%d bytes)", fullPath, written)
+	fmt.Fprintf(w, "Appended to %s (%d bytes)\n", requestPath, written)
+}
+
+// Handle DELETE requests - remove files
+func handleDelete(w http.ResponseWriter, r *http.Request) {
+	// Require an explicit confirmation so prefetchers and stray clients can't delete files
+	if r.URL.Query().Get("confirm") != "yes" {
+		http.Error(w, "Deletion requires confirmation, add ?confirm=yes to the request", http.StatusBadRequest)
+		return
+	}
+
+	// Clean the path to prevent directory traversal attacks
+	requestPath := filepath.Clean(r.URL.Path)
+	if requestPath == "/" || requestPath == "." {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+
+	// Remove leading slash for filepath.Join
+	requestPath = strings.TrimPrefix(requestPath, "/")
+
+	// Build the full path under the root this path maps to
+	root, fullPath := fsPath(requestPath)
+	if fullPath == root {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+
+	// Refuse symlinks that lead outside the upload directory
+	if !ensureWithinRoot(w, root, fullPath) {
+		return
+	}
+
+	// Check if path exists
+	info, err := os.Stat(fullPath)
+	if os.IsNotExist(err) {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error accessing path: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Directories are only removed when the path ends with a trailing slash
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Error(w, "Path is a directory, add a trailing slash to delete it", http.StatusConflict)
+			return
+		}
+		err = os.RemoveAll(fullPath)
+	} else {
+		err = os.Remove(fullPath)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	invalidateUsage(filepath.Dir(fullPath))
+	invalidateUsage(fullPath)
+	logf(r, "Deleted: %s", fullPath)
+	w.WriteHeader(http.StatusNoContent)
 }
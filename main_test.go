@@ -0,0 +1,570 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer serves a fresh temporary upload directory through
+// handleRequest, with the modes and buffer size main would set from flags
+func newTestServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	uploadDir = dir
+	dirMode, fileMode = 0o755, 0o644
+	bufferSize = 32 * 1024
+
+	srv := httptest.NewServer(http.HandlerFunc(handleRequest))
+	t.Cleanup(srv.Close)
+	return srv, dir
+}
+
+// writeFixture creates a file of size bytes with a repeating pattern
+func writeFixture(t *testing.T, path string, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte('a' + i%26)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// fetch sends a request and reads the whole body, failing the test when the
+// body doesn't match the declared Content-Length
+func fetch(t *testing.T, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: reading body: %v", req.Method, req.URL.Path, err)
+	}
+	return resp, body
+}
+
+func newRequest(t *testing.T, method, url string, body io.Reader) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestDownloadContentLength(t *testing.T) {
+	srv, dir := newTestServer(t)
+	data := writeFixture(t, filepath.Join(dir, "fixture.bin"), 1000)
+
+	resp, body := fetch(t, newRequest(t, http.MethodGet, srv.URL+"/fixture.bin", nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Length"); got != strconv.Itoa(len(data)) {
+		t.Errorf("Content-Length = %q, want %d", got, len(data))
+	}
+	if !bytes.Equal(body, data) {
+		t.Errorf("body differs from the fixture, got %d bytes", len(body))
+	}
+}
+
+func TestDownloadContentLengthWithoutBody(t *testing.T) {
+	srv, dir := newTestServer(t)
+	writeFixture(t, filepath.Join(dir, "fixture.bin"), 1000)
+	writeFixture(t, filepath.Join(dir, "index.html"), 1000)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		status int
+	}{
+		{"index redirect", "/index.html", "", "", http.StatusMovedPermanently},
+		{"if-match", "/fixture.bin", "If-Match", `"nope"`, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newRequest(t, http.MethodGet, srv.URL+tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			resp, body := fetch(t, req)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if resp.ContentLength >= 0 && resp.ContentLength != int64(len(body)) {
+				t.Errorf("Content-Length = %d, but the body has %d bytes", resp.ContentLength, len(body))
+			}
+		})
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	srv, dir := newTestServer(t)
+	data := writeFixture(t, filepath.Join(dir, "fixture.bin"), 1000)
+
+	req := newRequest(t, http.MethodGet, srv.URL+"/fixture.bin", nil)
+	req.Header.Set("Range", "bytes=100-199")
+	resp, body := fetch(t, req)
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", resp.StatusCode)
+	}
+	if got, want := resp.Header.Get("Content-Range"), "bytes 100-199/1000"; got != want {
+		t.Errorf("Content-Range = %q, want %q", got, want)
+	}
+	if !bytes.Equal(body, data[100:200]) {
+		t.Errorf("body = %q, want bytes 100-199 of the fixture", body)
+	}
+}
+
+func TestSymlinkEscape(t *testing.T) {
+	srv, dir := newTestServer(t)
+	if err := os.Symlink("/etc/passwd", filepath.Join(dir, "passwd")); err != nil {
+		t.Skipf("can't create symlinks: %v", err)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPut} {
+		t.Run(method, func(t *testing.T) {
+			var body io.Reader
+			if method == http.MethodPut {
+				body = strings.NewReader("overwritten")
+			}
+			resp, got := fetch(t, newRequest(t, method, srv.URL+"/passwd", body))
+			if resp.StatusCode != http.StatusForbidden {
+				t.Fatalf("status = %d, want 403", resp.StatusCode)
+			}
+			if bytes.Contains(got, []byte("root:")) {
+				t.Errorf("response leaked the link target: %q", got)
+			}
+		})
+	}
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	srv, dir := newTestServer(t)
+	path := filepath.Join(dir, "fixture.bin")
+	writeFixture(t, path, 1000)
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(path, modified, modified.Add(500*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _ := fetch(t, newRequest(t, http.MethodGet, srv.URL+"/fixture.bin", nil))
+	lastModified := resp.Header.Get("Last-Modified")
+	if want := modified.Format(http.TimeFormat); lastModified != want {
+		t.Fatalf("Last-Modified = %q, want %q", lastModified, want)
+	}
+
+	tests := []struct {
+		name  string
+		since string
+		want  int
+	}{
+		{"unchanged", lastModified, http.StatusNotModified},
+		{"later", modified.Add(time.Hour).Format(http.TimeFormat), http.StatusNotModified},
+		{"earlier", modified.Add(-time.Second).Format(http.TimeFormat), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newRequest(t, http.MethodGet, srv.URL+"/fixture.bin", nil)
+			req.Header.Set("If-Modified-Since", tt.since)
+			resp, body := fetch(t, req)
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want == http.StatusNotModified && len(body) != 0 {
+				t.Errorf("304 came with a %d byte body", len(body))
+			}
+		})
+	}
+}
+
+func TestMultipartTraversal(t *testing.T) {
+	srv, dir := newTestServer(t)
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, err := mw.CreateFormFile("file", "../../etc/cron.d/evil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, "* * * * * root id\n")
+	mw.Close()
+
+	req := newRequest(t, http.MethodPost, srv.URL+"/a/b/", &form)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, body := fetch(t, req)
+
+	// Either stored under its base name in the target directory or rejected
+	if resp.StatusCode == http.StatusCreated {
+		if _, err := os.Stat(filepath.Join(dir, "a", "b", "evil")); err != nil {
+			t.Errorf("upload not stored in the target directory: %v", err)
+		}
+	} else if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d (%s), want 201 or 400", resp.StatusCode, body)
+	}
+	for _, escaped := range []string{
+		filepath.Join(dir, "etc", "cron.d", "evil"),
+		filepath.Join(dir, "..", "..", "etc", "cron.d", "evil"),
+	} {
+		if _, err := os.Stat(escaped); err == nil {
+			t.Errorf("upload escaped the target directory to %s", escaped)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"../../etc/cron.d/evil", "evil"},
+		{"/etc/passwd", "passwd"},
+		{`C:\Users\me\..\evil.txt`, "evil.txt"},
+		{"report.pdf", "report.pdf"},
+		{"..", ""},
+		{"../", ""},
+		{"/", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := sanitizeFilename(tt.name); got != tt.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// chunkedBody hides the length of a body so the client sends it with
+// Transfer-Encoding: chunked
+func chunkedBody(data []byte) io.Reader {
+	return io.MultiReader(bytes.NewReader(data))
+}
+
+func TestChunkedUploadQuota(t *testing.T) {
+	srv, dir := newTestServer(t)
+	maxDirBytes = 1000
+	t.Cleanup(func() { maxDirBytes = 0 })
+
+	resp, body := fetch(t, newRequest(t, http.MethodPut, srv.URL+"/big.bin", chunkedBody(make([]byte, 1500))))
+	if resp.StatusCode != http.StatusInsufficientStorage {
+		t.Fatalf("status = %d (%s), want 507", resp.StatusCode, body)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "big.bin")); !os.IsNotExist(err) {
+		t.Errorf("rejected upload was stored: %v", err)
+	}
+
+	resp, body = fetch(t, newRequest(t, http.MethodPut, srv.URL+"/small.bin", chunkedBody(make([]byte, 800))))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status = %d (%s), want 201", resp.StatusCode, body)
+	}
+
+	// Appending past the quota leaves the file as it was
+	resp, body = fetch(t, newRequest(t, http.MethodPatch, srv.URL+"/small.bin", chunkedBody(make([]byte, 500))))
+	if resp.StatusCode != http.StatusInsufficientStorage {
+		t.Fatalf("PATCH status = %d (%s), want 507", resp.StatusCode, body)
+	}
+	info, err := os.Stat(filepath.Join(dir, "small.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 800 {
+		t.Errorf("size after rejected append = %d, want 800", info.Size())
+	}
+}
+
+func TestPatchOverflowKeepsFile(t *testing.T) {
+	srv, dir := newTestServer(t)
+	maxSize = 1000
+	t.Cleanup(func() { maxSize = 0 })
+	path := filepath.Join(dir, "log.txt")
+	data := writeFixture(t, path, 100)
+
+	resp, body := fetch(t, newRequest(t, http.MethodPatch, srv.URL+"/log.txt", chunkedBody(make([]byte, 1500))))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d (%s), want 413", resp.StatusCode, body)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("file has %d bytes after a rejected append, want the original %d", len(got), len(data))
+	}
+}
+
+func TestUserWithoutPassLeavesServerOpen(t *testing.T) {
+	_, dir := newTestServer(t)
+	authUser, authPass = "alice", ""
+	t.Cleanup(func() { authUser = "" })
+	srv := httptest.NewServer(accessControl(http.HandlerFunc(handleRequest)))
+	t.Cleanup(srv.Close)
+	writeFixture(t, filepath.Join(dir, "fixture.bin"), 100)
+
+	resp, body := fetch(t, newRequest(t, http.MethodGet, srv.URL+"/fixture.bin", nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET status = %d (%s), want 200", resp.StatusCode, body)
+	}
+	resp, body = fetch(t, newRequest(t, http.MethodPut, srv.URL+"/upload.txt", strings.NewReader("hello")))
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("PUT status = %d (%s), want 201", resp.StatusCode, body)
+	}
+}
+
+// putChunk sends one resumable upload chunk of data at start
+func putChunk(t *testing.T, url string, data []byte, start, total int, header http.Header) (*http.Response, []byte) {
+	t.Helper()
+	req := newRequest(t, http.MethodPut, url, bytes.NewReader(data))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+len(data)-1, total))
+	return fetch(t, req)
+}
+
+func TestChunkedUploadKeepsExistingFile(t *testing.T) {
+	srv, dir := newTestServer(t)
+	noOverwriteRename = true
+	t.Cleanup(func() { noOverwriteRename = false })
+	original := writeFixture(t, filepath.Join(dir, "report.txt"), 100)
+
+	putChunk(t, srv.URL+"/report.txt", []byte("HELLO"), 0, 10, nil)
+	resp, body := putChunk(t, srv.URL+"/report.txt", []byte("WORLD"), 5, 10, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status = %d (%s), want 201", resp.StatusCode, body)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "report.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, original) {
+		t.Errorf("existing file was overwritten")
+	}
+	stored := filepath.Join(dir, path.Base(resp.Header.Get("Location")))
+	if got, err := os.ReadFile(stored); err != nil || string(got) != "HELLOWORLD" {
+		t.Errorf("upload stored at %s = %q (%v), want HELLOWORLD", stored, got, err)
+	}
+}
+
+func TestChunkedUploadCreateOnly(t *testing.T) {
+	srv, dir := newTestServer(t)
+	createOnly := http.Header{"If-None-Match": {"*"}}
+
+	// Refused up front when the file already exists
+	original := writeFixture(t, filepath.Join(dir, "taken.txt"), 100)
+	resp, body := putChunk(t, srv.URL+"/taken.txt", []byte("HELLO"), 0, 10, createOnly)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("first chunk status = %d (%s), want 412", resp.StatusCode, body)
+	}
+
+	// And when another client creates it while the chunks are arriving
+	putChunk(t, srv.URL+"/race.txt", []byte("HELLO"), 0, 10, createOnly)
+	writeFixture(t, filepath.Join(dir, "race.txt"), 100)
+	resp, body = putChunk(t, srv.URL+"/race.txt", []byte("WORLD"), 5, 10, createOnly)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("last chunk status = %d (%s), want 412", resp.StatusCode, body)
+	}
+	for _, name := range []string{"taken.txt", "race.txt"} {
+		if got, _ := os.ReadFile(filepath.Join(dir, name)); !bytes.Equal(got, original) {
+			t.Errorf("%s was replaced by a create-only upload", name)
+		}
+	}
+}
+
+func TestChunkedUploadIfMatch(t *testing.T) {
+	srv, dir := newTestServer(t)
+	target := filepath.Join(dir, "doc.txt")
+	writeFixture(t, target, 100)
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifMatch := http.Header{"If-Match": {fileETag(info)}}
+
+	resp, body := putChunk(t, srv.URL+"/doc.txt", []byte("HELLO"), 0, 10, http.Header{"If-Match": {`"stale"`}})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("stale ETag status = %d (%s), want 412", resp.StatusCode, body)
+	}
+
+	// Another client replaces the file between the chunks
+	putChunk(t, srv.URL+"/doc.txt", []byte("HELLO"), 0, 10, ifMatch)
+	replaced := writeFixture(t, target, 200)
+	resp, body = putChunk(t, srv.URL+"/doc.txt", []byte("WORLD"), 5, 10, ifMatch)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("last chunk status = %d (%s), want 412", resp.StatusCode, body)
+	}
+	if got, _ := os.ReadFile(target); !bytes.Equal(got, replaced) {
+		t.Errorf("upload overwrote a file whose ETag no longer matched")
+	}
+}
+
+func TestChunkedUploadReplaceStatus(t *testing.T) {
+	srv, dir := newTestServer(t)
+
+	putChunk(t, srv.URL+"/new.txt", []byte("HELLO"), 0, 10, nil)
+	resp, body := putChunk(t, srv.URL+"/new.txt", []byte("WORLD"), 5, 10, nil)
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") == "" {
+		t.Fatalf("new file: status = %d, Location = %q (%s), want 201 with Location",
+			resp.StatusCode, resp.Header.Get("Location"), body)
+	}
+
+	writeFixture(t, filepath.Join(dir, "old.txt"), 100)
+	putChunk(t, srv.URL+"/old.txt", []byte("HELLO"), 0, 10, nil)
+	resp, body = putChunk(t, srv.URL+"/old.txt", []byte("WORLD"), 5, 10, nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Location") != "" {
+		t.Fatalf("replaced file: status = %d, Location = %q (%s), want 200 without Location",
+			resp.StatusCode, resp.Header.Get("Location"), body)
+	}
+}
+
+func TestChunkedUploadContentMD5(t *testing.T) {
+	srv, dir := newTestServer(t)
+	contentMD5 := func(data string) http.Header {
+		sum := md5.Sum([]byte(data))
+		return http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(sum[:])}}
+	}
+
+	putChunk(t, srv.URL+"/file.txt", []byte("HELLO"), 0, 10, contentMD5("HELLO"))
+	resp, body := putChunk(t, srv.URL+"/file.txt", []byte("WORLD"), 5, 10, contentMD5("world"))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("mismatched chunk status = %d (%s), want 400", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Range"); got != "bytes=0-4" {
+		t.Errorf("Range after a mismatched chunk = %q, want bytes=0-4", got)
+	}
+
+	resp, body = putChunk(t, srv.URL+"/file.txt", []byte("WORLD"), 5, 10, contentMD5("WORLD"))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("resent chunk status = %d (%s), want 201", resp.StatusCode, body)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "file.txt")); string(got) != "HELLOWORLD" {
+		t.Errorf("stored %q, want HELLOWORLD", got)
+	}
+}
+
+func TestChunkedUploadLongName(t *testing.T) {
+	srv, dir := newTestServer(t)
+	name := strings.Repeat("n", maxNameLength-4) + ".txt"
+
+	resp, body := putChunk(t, srv.URL+"/"+name, []byte("HELLO"), 0, 10, nil)
+	if resp.StatusCode != statusResumeIncomplete {
+		t.Fatalf("first chunk status = %d (%s), want 308", resp.StatusCode, body)
+	}
+	resp, body = putChunk(t, srv.URL+"/"+name, []byte("WORLD"), 5, 10, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("last chunk status = %d (%s), want 201", resp.StatusCode, body)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != "HELLOWORLD" {
+		t.Errorf("stored %q, want HELLOWORLD", got)
+	}
+}
+
+func TestSweepExpiredKeepsOnlyAccessFiles(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{accessFileName, ".upload-123", partialPrefix + "big.iso", "report.txt"} {
+		path := filepath.Join(dir, name)
+		writeFixture(t, path, 10)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sweepExpired(dir, time.Hour)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != accessFileName {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("left after sweep: %v, want only %s", names, accessFileName)
+	}
+}
+
+func TestZipLeavesOutHiddenFiles(t *testing.T) {
+	srv, dir := newTestServer(t)
+	hidePatterns = patternList{".*", "*.log"}
+	t.Cleanup(func() { hidePatterns = patternList{".*"} })
+	writeFixture(t, filepath.Join(dir, "report.txt"), 10)
+	writeFixture(t, filepath.Join(dir, "debug.log"), 10)
+
+	resp, body := fetch(t, newRequest(t, http.MethodGet, srv.URL+"/?download=zip", nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if len(names) != 1 || names[0] != "report.txt" {
+		t.Errorf("archive holds %v, want only report.txt", names)
+	}
+}
+
+func TestRouteUploadUnderMappedRoot(t *testing.T) {
+	srv, _ := newTestServer(t)
+	mapped := t.TempDir()
+	rootMaps = rootMapList{{prefix: "/pics", dir: mapped}}
+	uploadRoutes = uploadRouteMap{"image/*": "images"}
+	t.Cleanup(func() {
+		rootMaps = nil
+		uploadRoutes = uploadRouteMap{}
+	})
+
+	req := newRequest(t, http.MethodPut, srv.URL+"/pics/cat.png", strings.NewReader("PNG"))
+	req.Header.Set("Content-Type", "image/png")
+	resp, body := fetch(t, req)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status = %d (%s), want 201", resp.StatusCode, body)
+	}
+	if got, err := os.ReadFile(filepath.Join(mapped, "images", "cat.png")); err != nil || string(got) != "PNG" {
+		t.Errorf("routed file in mapped root: %q, %v", got, err)
+	}
+}
+
+func TestPropfindNoListing(t *testing.T) {
+	srv, dir := newTestServer(t)
+	noListing = true
+	t.Cleanup(func() { noListing = false })
+	writeFixture(t, filepath.Join(dir, "secret.txt"), 10)
+
+	for depth, want := range map[string]int{"0": http.StatusMultiStatus, "1": http.StatusForbidden, "infinity": http.StatusForbidden} {
+		req := newRequest(t, "PROPFIND", srv.URL+"/", nil)
+		req.Header.Set("Depth", depth)
+		resp, body := fetch(t, req)
+		if resp.StatusCode != want {
+			t.Errorf("Depth %s: status = %d, want %d", depth, resp.StatusCode, want)
+		}
+		if strings.Contains(string(body), "secret.txt") {
+			t.Errorf("Depth %s: response lists a child", depth)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/goldmark"
+)
+
+// isMarkdownFile reports whether filePath has a markdown extension
+func isMarkdownFile(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// serveMarkdown renders a markdown file and serves it as an HTML page.
+// Raw HTML embedded in the markdown is not passed through.
+func serveMarkdown(w http.ResponseWriter, r *http.Request, filePath string) {
+	source, err := os.ReadFile(filePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var rendered bytes.Buffer
+	if err := goldmark.Convert(source, &rendered); err != nil {
+		http.Error(w, fmt.Sprintf("Error rendering markdown: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w, closeGzip := maybeGzip(w, r, "text/html")
+	defer closeGzip()
+
+	logf(r, "Serving rendered markdown: %s", filePath)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<html><head><meta charset=\"utf-8\"><title>%s</title></head><body>\n", html.EscapeString(filepath.Base(filePath)))
+	w.Write(rendered.Bytes())
+	fmt.Fprintf(w, "</body></html>\n")
+}
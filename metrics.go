@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus collectors, registered on their own registry by -metrics
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	uploadsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "goupload_uploads_total",
+		Help: "Successful uploads (PUT, POST and PATCH).",
+	})
+	downloadsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "goupload_downloads_total",
+		Help: "Successful GET requests.",
+	})
+	bytesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "goupload_bytes_received_total",
+		Help: "Request body bytes read.",
+	})
+	bytesSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "goupload_bytes_sent_total",
+		Help: "Response body bytes written.",
+	})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "goupload_request_duration_seconds",
+		Help:    "Time taken to serve requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "code"})
+)
+
+// metricsHandler registers the collectors and returns the /metrics handler
+func metricsHandler() http.Handler {
+	metricsRegistry.MustRegister(uploadsTotal, downloadsTotal, bytesReceived, bytesSent, requestDuration)
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// instrumentRequests records per-request metrics once the handler has finished
+func instrumentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+
+		next.ServeHTTP(rw, r)
+
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := status >= 200 && status < 300
+		switch r.Method {
+		case http.MethodPut, http.MethodPost, http.MethodPatch:
+			if success {
+				uploadsTotal.Inc()
+			}
+		case http.MethodGet:
+			if success {
+				downloadsTotal.Inc()
+			}
+		}
+		bytesReceived.Add(float64(body.bytes))
+		bytesSent.Add(float64(rw.bytes))
+		requestDuration.WithLabelValues(metricMethod(r.Method), strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// metricMethod folds unknown methods into one label value so clients can't
+// create unbounded series
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions, "MOVE", "COPY", "PROPFIND":
+		return method
+	}
+	return "other"
+}
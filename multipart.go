@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Memory used for multipart parsing before parts spill over to temp files
+const multipartMaxMemory = 32 << 20
+
+// Handle POST requests - multipart/form-data uploads from HTML forms, raw
+// bodies written to the path just like PUT for clients that can only POST,
+// or file operations for clients that can't send custom methods
+func handlePost(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("action") {
+	case "":
+	case "move":
+		handleMove(w, r)
+		return
+	case "copy":
+		handleCopy(w, r)
+		return
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+
+	if !isMultipartForm(r) {
+		handlePut(w, r)
+		return
+	}
+
+	// Files are stored in the directory named by the request path
+	requestPath := filepath.Clean(r.URL.Path)
+	if requestPath == "." {
+		requestPath = "/"
+	}
+	root, targetDir := fsPath(requestPath)
+	if !ensureWithinRoot(w, root, targetDir) {
+		return
+	}
+
+	// Bound the number of uploads hitting the disk at once
+	if !acquireUploadSlot(w, r) {
+		return
+	}
+	defer releaseUploadSlot()
+
+	// Keep slow but steady uploads alive past -read-timeout
+	extendReadDeadline(w, r)
+	if maxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+	if err := r.ParseMultipartForm(multipartMaxMemory); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, fmt.Sprintf("Upload exceeds maximum upload size of %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to parse multipart form: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	if !ensureParentDir(w, targetDir) {
+		return
+	}
+
+	var summary strings.Builder
+	saved := 0
+	for _, headers := range r.MultipartForm.File {
+		for _, fh := range headers {
+			name := sanitizeFilename(fh.Filename)
+			if name == "" {
+				http.Error(w, fmt.Sprintf("Invalid file name: %q", fh.Filename), http.StatusBadRequest)
+				return
+			}
+			if !ensureNameLengths(w, name) || !ensureNoDotfiles(w, name) {
+				return
+			}
+			if name == accessFileName {
+				http.Error(w, "Access files can't be read or written over HTTP", http.StatusForbidden)
+				return
+			}
+
+			fullPath := filepath.Join(targetDir, name)
+			if !ensureWithinRoot(w, root, fullPath) {
+				return
+			}
+			if !ensureAllowedExtension(w, name) {
+				return
+			}
+			if !ensureQuota(w, fullPath, fh.Size) {
+				return
+			}
+			written, err := saveMultipartFile(fh, fullPath)
+			if err != nil {
+				if diskFull(w, r, err) {
+					return
+				}
+				http.Error(w, fmt.Sprintf("Failed to save %s: %v", name, err), http.StatusInternalServerError)
+				return
+			}
+
+			invalidateUsage(targetDir)
+			logf(r, "Uploaded file: %s (%d bytes)", fullPath, written)
+			notifyUpload(r, path.Join(r.URL.Path, name), written)
+			fmt.Fprintf(&summary, "  %s (%d bytes)\n", name, written)
+			saved++
+		}
+	}
+
+	if saved == 0 {
+		http.Error(w, "No files found in form", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "Saved %d file(s):\n%s", saved, summary.String())
+}
+
+// isMultipartForm reports whether the request body is multipart/form-data
+func isMultipartForm(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// sanitizeFilename reduces a client supplied file name to its base name.
+// Backslashes count as separators too, since browsers on Windows may send a
+// full C:\ path. An empty string is returned when nothing usable remains,
+// including names made up of dots only such as "..".
+func sanitizeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "/" || strings.Trim(name, ".") == "" || strings.ContainsRune(name, 0) {
+		return ""
+	}
+	return name
+}
+
+// saveMultipartFile copies a single uploaded part to a temp file next to
+// fullPath and renames it into place, so a failed part never clobbers the
+// file it was replacing
+func saveMultipartFile(fh *multipart.FileHeader, fullPath string) (int64, error) {
+	src, err := fh.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(filepath.Dir(fullPath), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+
+	written, err := copyBuffered(dst, src)
+	if err != nil {
+		return 0, err
+	}
+	if err := dst.Chmod(fileMode); err != nil {
+		return 0, err
+	}
+	if err := dst.Close(); err != nil {
+		return 0, err
+	}
+	return written, os.Rename(dst.Name(), fullPath)
+}
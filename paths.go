@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Longest file or directory name, in bytes, most filesystems accept
+const maxNameLength = 255
+
+// errPathEscapes is returned when a path resolves outside the upload directory
+var errPathEscapes = errors.New("path escapes upload directory")
+
+// rootMap serves a URL prefix from a directory other than -d
+type rootMap struct {
+	prefix string
+	dir    string
+}
+
+// rootMapList collects repeated -map prefix=dir flags
+type rootMapList []rootMap
+
+// Root directories by URL prefix, in addition to uploadDir
+var rootMaps rootMapList
+
+func (l *rootMapList) String() string {
+	var parts []string
+	for _, m := range *l {
+		parts = append(parts, m.prefix+"="+m.dir)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *rootMapList) Set(value string) error {
+	prefix, dir, ok := strings.Cut(value, "=")
+	if !ok || dir == "" {
+		return errors.New("expected prefix=dir")
+	}
+	prefix = path.Clean("/" + prefix)
+	if prefix == "/" {
+		return errors.New("prefix must not be /, use -d for the default root")
+	}
+	*l = append(*l, rootMap{prefix: prefix, dir: dir})
+	return nil
+}
+
+// resolveRoot picks the root directory for a URL path using the longest
+// matching -map prefix, falling back to uploadDir. rel is the remainder of
+// the path below that root.
+func resolveRoot(urlPath string) (root, rel string) {
+	urlPath = path.Clean("/" + filepath.ToSlash(urlPath))
+
+	root, rel = uploadDir, urlPath
+	longest := 0
+	for _, m := range rootMaps {
+		if len(m.prefix) <= longest {
+			continue
+		}
+		if urlPath == m.prefix || strings.HasPrefix(urlPath, m.prefix+"/") {
+			root, rel = m.dir, strings.TrimPrefix(urlPath, m.prefix)
+			longest = len(m.prefix)
+		}
+	}
+	return root, rel
+}
+
+// fsPath maps a cleaned request path to its root directory and its location
+// on disk. fullPath equals root when the path names the root itself.
+func fsPath(requestPath string) (root, fullPath string) {
+	root, rel := resolveRoot(requestPath)
+	return root, filepath.Join(root, filepath.FromSlash(rel))
+}
+
+// checkWithinRoot resolves symlinks in fullPath and verifies the result still
+// lies inside root. Paths that don't exist yet, such as upload targets, are
+// checked through their nearest existing ancestor.
+func checkWithinRoot(root, fullPath string) error {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	resolved, err := resolveExisting(fullPath)
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errPathEscapes
+	}
+	return nil
+}
+
+// resolveExisting evaluates symlinks for the longest existing prefix of path
+// and appends the remaining, not yet created, components unchanged
+func resolveExisting(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// A dangling symlink would be followed when the file is created, so
+	// resolve it through its target rather than treating it as missing
+	if target, err := os.Readlink(path); err == nil {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		return resolveExisting(target)
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	resolvedParent, err := resolveExisting(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolvedParent, filepath.Base(path)), nil
+}
+
+// ensureWithinRoot writes an error response and returns false when fullPath
+// can't be verified to stay inside root
+func ensureWithinRoot(w http.ResponseWriter, root, fullPath string) bool {
+	err := checkWithinRoot(root, fullPath)
+	if errors.Is(err, errPathEscapes) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error resolving path: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// pathDepth counts the segments of a cleaned URL path, "/" being 0 deep
+func pathDepth(urlPath string) int {
+	cleaned := strings.Trim(path.Clean("/"+urlPath), "/")
+	if cleaned == "" {
+		return 0
+	}
+	return strings.Count(cleaned, "/") + 1
+}
+
+// ensureNameLengths writes a 400 response and returns false when any segment
+// of urlPath is longer than maxNameLength, which the filesystem would only
+// refuse later with a cryptic "file name too long"
+func ensureNameLengths(w http.ResponseWriter, urlPath string) bool {
+	for _, name := range strings.Split(urlPath, "/") {
+		if len(name) > maxNameLength {
+			http.Error(w, fmt.Sprintf("Name is %d bytes long, the limit is %d: %.32s...", len(name), maxNameLength, name), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
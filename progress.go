@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How often /progress streams send an update while bytes are arriving
+	progressInterval = 250 * time.Millisecond
+	// How long a finished upload's progress stays around for late subscribers
+	progressLinger = time.Minute
+	// Entries that never see an upload are dropped after this long
+	progressStale = time.Hour
+	// Longest X-Upload-Id that is tracked
+	maxUploadIDLen = 128
+)
+
+// Upload states reported on /progress streams
+const (
+	progressWaiting int32 = iota
+	progressUploading
+	progressDone
+	progressFailed
+)
+
+// uploadProgress is the live state of one upload, updated by the PUT and
+// read by any number of /progress streams
+type uploadProgress struct {
+	received atomic.Int64
+	total    atomic.Int64 // -1 when the client didn't send a Content-Length
+	state    atomic.Int32
+	created  time.Time
+}
+
+// progressRegistry tracks uploads by their client chosen X-Upload-Id
+type progressRegistry struct {
+	mu      sync.Mutex
+	uploads map[string]*uploadProgress
+}
+
+var uploadProgresses = &progressRegistry{uploads: make(map[string]*uploadProgress)}
+
+// get returns the progress for id, creating it so a stream can be opened
+// before the upload starts
+func (p *progressRegistry) get(id string) *uploadProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, up := range p.uploads {
+		if up.state.Load() == progressWaiting && time.Since(up.created) > progressStale {
+			delete(p.uploads, key)
+		}
+	}
+
+	up, ok := p.uploads[id]
+	if !ok {
+		up = &uploadProgress{created: time.Now()}
+		up.total.Store(-1)
+		p.uploads[id] = up
+	}
+	return up
+}
+
+// finish marks an upload as complete or failed and forgets it after progressLinger
+func (p *progressRegistry) finish(id string, up *uploadProgress, ok bool) {
+	if ok {
+		up.state.Store(progressDone)
+	} else {
+		up.state.Store(progressFailed)
+	}
+	time.AfterFunc(progressLinger, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.uploads[id] == up {
+			delete(p.uploads, id)
+		}
+	})
+}
+
+// progressReader counts the bytes of an upload body as they are read
+type progressReader struct {
+	io.Reader
+	progress *uploadProgress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.Reader.Read(b)
+	pr.progress.received.Add(int64(n))
+	return n, err
+}
+
+// trackProgress wraps body so its progress is published under the request's
+// X-Upload-Id. done must be called with the outcome once the upload ends.
+// Requests without an id get body back unchanged.
+func trackProgress(r *http.Request, body io.Reader) (tracked io.Reader, done func(ok bool)) {
+	id := r.Header.Get("X-Upload-Id")
+	if id == "" || len(id) > maxUploadIDLen {
+		return body, func(bool) {}
+	}
+
+	up := uploadProgresses.get(id)
+	up.received.Store(0)
+	up.total.Store(r.ContentLength)
+	up.state.Store(progressUploading)
+	return &progressReader{Reader: body, progress: up}, func(ok bool) {
+		uploadProgresses.finish(id, up, ok)
+	}
+}
+
+// Handle GET /progress/<id> - stream the progress of an upload as
+// server-sent events until it completes, fails or the client goes away
+func handleProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/progress/")
+	if id == "" || len(id) > maxUploadIDLen {
+		http.Error(w, "Invalid upload id", http.StatusBadRequest)
+		return
+	}
+
+	// Streams outlive -write-timeout, and each event must go out right away
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && writeTimeout > 0 {
+		logf(r, "Progress stream for %s may be cut off by -write-timeout: %v", id, err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	up := uploadProgresses.get(id)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	lastSent := int64(-1)
+	for {
+		state := up.state.Load()
+		received := up.received.Load()
+		event := "progress"
+		switch state {
+		case progressDone:
+			event = "done"
+		case progressFailed:
+			event = "failed"
+		}
+
+		if received != lastSent || state >= progressDone {
+			fmt.Fprintf(w, "event: %s\ndata: {\"received\":%d,\"total\":%d}\n\n", event, received, up.total.Load())
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			lastSent = received
+		}
+		if state >= progressDone {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
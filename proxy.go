@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// clientIP returns the address of the client, honoring X-Forwarded-For only
+// when -trust-proxy is set. The rightmost entry is the one the trusted proxy
+// appended, anything to its left came from the client and can be forged.
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			forwarded := values[len(values)-1]
+			if last := strings.TrimSpace(forwarded[strings.LastIndex(forwarded, ",")+1:]); last != "" {
+				return last
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestScheme returns the scheme the client used, taking X-Forwarded-Proto
+// into account only when -trust-proxy is set
+func requestScheme(r *http.Request) string {
+	if trustProxy {
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// absoluteURL builds the URL a client should use to reach urlPath on this server
+func absoluteURL(r *http.Request, urlPath string) string {
+	u := url.URL{
+		Scheme: requestScheme(r),
+		Host:   r.Host,
+		Path:   "/" + strings.TrimPrefix(urlPath, "/"),
+	}
+	return u.String()
+}
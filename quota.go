@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// errQuotaExceeded is returned when a write would exceed -max-files or -max-dir-bytes
+var errQuotaExceeded = errors.New("directory quota exceeded")
+
+// dirUsage counts the files directly inside a directory and their total size
+type dirUsage struct {
+	files int
+	bytes int64
+}
+
+var (
+	usageMu    sync.Mutex
+	usageCache = make(map[string]dirUsage)
+)
+
+// quotaEnabled reports whether any per-directory limit is configured
+func quotaEnabled() bool {
+	return maxDirFiles > 0 || maxDirBytes > 0
+}
+
+// directoryUsage returns the usage of dir, scanning it only when it isn't cached.
+// In-progress upload temp files are not counted.
+func directoryUsage(dir string) (dirUsage, error) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	if usage, ok := usageCache[dir]; ok {
+		return usage, nil
+	}
+
+	var usage dirUsage
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return usage, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		usage.files++
+		usage.bytes += info.Size()
+	}
+	usageCache[dir] = usage
+	return usage, nil
+}
+
+// invalidateUsage drops cached usage for dir and everything below it after a write
+func invalidateUsage(dir string) {
+	if !quotaEnabled() {
+		return
+	}
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	prefix := dir + string(filepath.Separator)
+	for cached := range usageCache {
+		if cached == dir || strings.HasPrefix(cached, prefix) {
+			delete(usageCache, cached)
+		}
+	}
+}
+
+// checkQuota verifies that writing fullPath with the given final size keeps
+// its directory within the configured limits. A replaced file's old size is
+// discounted and it doesn't count as an additional file.
+func checkQuota(fullPath string, size int64) error {
+	if !quotaEnabled() {
+		return nil
+	}
+	usage, err := directoryUsage(filepath.Dir(fullPath))
+	if err != nil {
+		return err
+	}
+
+	files, bytes := usage.files+1, usage.bytes+size
+	if existing, err := os.Stat(fullPath); err == nil && !existing.IsDir() {
+		files--
+		bytes -= existing.Size()
+	}
+
+	if maxDirFiles > 0 && files > maxDirFiles {
+		return fmt.Errorf("%w: directory already holds %d files (limit %d)", errQuotaExceeded, usage.files, maxDirFiles)
+	}
+	if maxDirBytes > 0 && bytes > maxDirBytes {
+		return fmt.Errorf("%w: directory would hold %d bytes (limit %d)", errQuotaExceeded, bytes, maxDirBytes)
+	}
+	return nil
+}
+
+// quotaReader fails a body once it has produced more than remaining bytes,
+// so bodies of unknown length are held to -max-dir-bytes as they stream in
+type quotaReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	q.remaining -= int64(n)
+	if q.remaining < 0 {
+		return n, fmt.Errorf("%w: directory would exceed %d bytes", errQuotaExceeded, maxDirBytes)
+	}
+	return n, err
+}
+
+// limitToQuota caps body at the bytes fullPath's directory still has room
+// for once fullPath holds currentSize bytes. Chunked and gzip encoded bodies
+// only reveal their size while being copied, so checkQuota can't catch them
+// up front.
+func limitToQuota(body io.Reader, fullPath string, currentSize int64) (io.Reader, error) {
+	if maxDirBytes <= 0 {
+		return body, nil
+	}
+	usage, err := directoryUsage(filepath.Dir(fullPath))
+	if err != nil {
+		return body, err
+	}
+	used := usage.bytes
+	if existing, err := os.Stat(fullPath); err == nil && !existing.IsDir() {
+		used -= existing.Size()
+	}
+	return &quotaReader{r: body, remaining: maxDirBytes - used - currentSize}, nil
+}
+
+// checkTreeQuota verifies that a copy of the directory tree at src fits the
+// per-directory limits. Every copied directory holds the same files as its
+// source, so the usage of the sources is what the copies will have.
+func checkTreeQuota(src string) error {
+	if !quotaEnabled() {
+		return nil
+	}
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		usage, err := directoryUsage(path)
+		if err != nil {
+			return err
+		}
+		if maxDirFiles > 0 && usage.files > maxDirFiles {
+			return fmt.Errorf("%w: %s holds %d files (limit %d)", errQuotaExceeded, d.Name(), usage.files, maxDirFiles)
+		}
+		if maxDirBytes > 0 && usage.bytes > maxDirBytes {
+			return fmt.Errorf("%w: %s holds %d bytes (limit %d)", errQuotaExceeded, d.Name(), usage.bytes, maxDirBytes)
+		}
+		return nil
+	})
+}
+
+// ensureQuota writes an error response and returns false when checkQuota fails
+func ensureQuota(w http.ResponseWriter, fullPath string, size int64) bool {
+	return quotaChecked(w, checkQuota(fullPath, size))
+}
+
+// ensureTreeQuota writes an error response and returns false when
+// checkTreeQuota fails
+func ensureTreeQuota(w http.ResponseWriter, src string) bool {
+	return quotaChecked(w, checkTreeQuota(src))
+}
+
+// quotaChecked answers 507 for an exceeded quota and 500 for any other error
+// from a quota check
+func quotaChecked(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, errQuotaExceeded) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error checking quota: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// diskFull answers 507 and returns true when err means the filesystem ran
+// out of space. Partial files are left to the caller's usual cleanup.
+func diskFull(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, syscall.ENOSPC) {
+		return false
+	}
+	logf(r, "Disk full while storing %s", r.URL.Path)
+	http.Error(w, "Insufficient storage, the disk is full", http.StatusInsufficientStorage)
+	return true
+}
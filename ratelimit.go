@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// How long a client's limiter is kept after its last request
+const limiterIdleTimeout = 5 * time.Minute
+
+// ipLimiter keeps a token bucket per client IP
+type ipLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*limiterEntry
+	limit    rate.Limit
+	burst    int
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newIPLimiter(limit rate.Limit, burst int) *ipLimiter {
+	l := &ipLimiter{
+		limiters: make(map[string]*limiterEntry),
+		limit:    limit,
+		burst:    burst,
+	}
+	go l.cleanup()
+	return l
+}
+
+// get returns the limiter for ip, creating it on first use
+func (l *ipLimiter) get(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.limiters[ip]
+	if !ok {
+		entry = &limiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[ip] = entry
+	}
+	entry.lastSeen = time.Now()
+	return entry.limiter
+}
+
+// cleanup periodically drops limiters of clients that have gone quiet
+func (l *ipLimiter) cleanup() {
+	for range time.Tick(time.Minute) {
+		l.mu.Lock()
+		for ip, entry := range l.limiters {
+			if time.Since(entry.lastSeen) > limiterIdleTimeout {
+				delete(l.limiters, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// rateLimit rejects uploads and other writes from clients that exceed -rate.
+// Reads are never limited.
+func rateLimit(next http.Handler) http.Handler {
+	limiter := newIPLimiter(rate.Limit(uploadRate), uploadBurst)
+	log.Printf("Rate limiting writes to %g requests/sec per client (burst %d)", uploadRate, uploadBurst)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isReadMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reservation := limiter.get(clientIP(r)).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			// Don't consume a token for a request we're rejecting
+			reservation.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// Header carrying the request ID, both from upstream proxies and in responses
+const requestIDHeader = "X-Request-Id"
+
+// Longest incoming request ID that is reused rather than replaced
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// requestIDs tags every request with an ID, reusing the X-Request-Id set by
+// a proxy in front of us when there is one, and echoes it in the response
+// so a single upload can be followed across hops
+func requestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newUploadID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID accepts short printable IDs, so a client can't inject
+// whitespace or control characters into our logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID assigned by requestIDs, or "" outside of it
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// logf logs like log.Printf, prefixing the line with the request's ID
+func logf(r *http.Request, format string, args ...any) {
+	if id := requestID(r); id != "" {
+		log.Printf("[%s] "+format, append([]any{id}, args...)...)
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Status code used by resumable upload clients to signal a partial upload
+const statusResumeIncomplete = http.StatusPermanentRedirect
+
+// contentRange is a parsed "bytes start-end/total" header. start and end are
+// -1 for the "bytes */total" form used to query progress, and total is -1
+// when the client doesn't know the final size yet.
+type contentRange struct {
+	start, end, total int64
+}
+
+// parseContentRange parses the Content-Range header of a PUT chunk
+func parseContentRange(header string) (contentRange, error) {
+	cr := contentRange{start: -1, end: -1, total: -1}
+
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return cr, errors.New("unit must be bytes")
+	}
+	rangePart, totalPart, ok := strings.Cut(strings.TrimSpace(spec), "/")
+	if !ok {
+		return cr, errors.New("missing total size")
+	}
+
+	var err error
+	if totalPart != "*" {
+		if cr.total, err = strconv.ParseInt(totalPart, 10, 64); err != nil || cr.total < 0 {
+			return cr, errors.New("invalid total size")
+		}
+	}
+
+	if rangePart == "*" {
+		if cr.total < 0 {
+			return cr, errors.New("status queries need a known total size")
+		}
+		return cr, nil
+	}
+
+	startPart, endPart, ok := strings.Cut(rangePart, "-")
+	if !ok {
+		return cr, errors.New("invalid byte range")
+	}
+	if cr.start, err = strconv.ParseInt(startPart, 10, 64); err != nil || cr.start < 0 {
+		return cr, errors.New("invalid range start")
+	}
+	if cr.end, err = strconv.ParseInt(endPart, 10, 64); err != nil || cr.end < cr.start {
+		return cr, errors.New("invalid range end")
+	}
+	if cr.total >= 0 && cr.end >= cr.total {
+		return cr, errors.New("range exceeds total size")
+	}
+	return cr, nil
+}
+
+// Prefix of the partial files resumable uploads collect in
+const partialPrefix = ".upload-resume-"
+
+// partialPath is where the chunks of a resumable upload to fullPath collect
+// until the last one arrives. The .upload- prefix keeps it out of quotas.
+// Names too long to take the prefix are replaced by their SHA-256.
+func partialPath(fullPath string) string {
+	name := filepath.Base(fullPath)
+	if len(partialPrefix+name) > maxNameLength {
+		sum := sha256.Sum256([]byte(name))
+		name = hex.EncodeToString(sum[:])
+	}
+	return filepath.Join(filepath.Dir(fullPath), partialPrefix+name)
+}
+
+// receivedBytes returns how much of a resumable upload to fullPath has been
+// received so far. Chunks are only accepted at or before this offset, so the
+// partial file never has gaps.
+func receivedBytes(fullPath string) (int64, error) {
+	info, err := os.Stat(partialPath(fullPath))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// setReceivedRange tells the client how much has been received so it can
+// continue from there
+func setReceivedRange(w http.ResponseWriter, received int64) {
+	if received > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", received-1))
+	}
+}
+
+// Handle a resumable upload chunk - write the body at its offset in the
+// partial file, and move that into place once every byte up to the total
+// has arrived
+func handleChunk(w http.ResponseWriter, r *http.Request, fullPath, requestPath string) {
+	cr, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid Content-Range: %v", err), http.StatusBadRequest)
+		return
+	}
+	if maxSize > 0 && (cr.total > maxSize || cr.end >= maxSize) {
+		http.Error(w, fmt.Sprintf("File exceeds maximum upload size of %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	received, err := receivedBytes(fullPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error accessing partial upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// "bytes */total" only asks how far the upload has got
+	if cr.start < 0 {
+		setReceivedRange(w, received)
+		w.WriteHeader(statusResumeIncomplete)
+		return
+	}
+
+	// A chunk past the received offset would leave a gap
+	if cr.start > received {
+		setReceivedRange(w, received)
+		http.Error(w, fmt.Sprintf("Chunk starts at %d but only %d bytes have been received", cr.start, received), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	// "If-None-Match: *" asks for a create-only upload. Fail fast on the first
+	// chunk, linking the last one into place enforces it atomically.
+	createOnly := r.Header.Get("If-None-Match") == "*"
+	if _, err := os.Lstat(fullPath); createOnly && cr.start == 0 && err == nil {
+		http.Error(w, "File already exists", http.StatusPreconditionFailed)
+		return
+	}
+
+	// "If-Match: <etag>" must hold for every chunk, so a client replacing the
+	// version it last saw learns early when another one got there first
+	ifMatch := r.Header.Get("If-Match")
+	if !ifMatchHolds(ifMatch, fullPath) {
+		http.Error(w, "File has changed, ETag does not match", http.StatusPreconditionFailed)
+		return
+	}
+
+	finalSize := cr.total
+	if finalSize < 0 {
+		finalSize = cr.end + 1
+	}
+	if !ensureQuota(w, fullPath, finalSize) {
+		return
+	}
+
+	length := cr.end - cr.start + 1
+	if r.ContentLength >= 0 && r.ContentLength != length {
+		http.Error(w, "Content-Length does not match Content-Range", http.StatusBadRequest)
+		return
+	}
+
+	// Starting again from zero discards whatever an earlier attempt left
+	flags := os.O_WRONLY | os.O_CREATE
+	if cr.start == 0 {
+		flags |= os.O_TRUNC
+	}
+	partial := partialPath(fullPath)
+	file, err := os.OpenFile(partial, flags, fileMode)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(cr.start, io.SeekStart); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to seek: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// Content-MD5 covers just this chunk's bytes
+	var body io.Reader = io.LimitReader(r.Body, length)
+	expectedMD5 := r.Header.Get("Content-MD5")
+	md5Hash := md5.New()
+	if expectedMD5 != "" {
+		body = io.TeeReader(body, md5Hash)
+	}
+	written, err := copyBuffered(file, body)
+	if err != nil {
+		if diskFull(w, r, err) {
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to write chunk: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if written != length {
+		http.Error(w, fmt.Sprintf("Incomplete chunk: got %d of %d bytes", written, length), http.StatusBadRequest)
+		return
+	}
+	if expectedMD5 != "" {
+		actualMD5 := base64.StdEncoding.EncodeToString(md5Hash.Sum(nil))
+		if actualMD5 != expectedMD5 {
+			// Drop the corrupt chunk so the client resends it from its start
+			if err := file.Truncate(cr.start); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to discard chunk: %v", err), http.StatusInternalServerError)
+				return
+			}
+			setReceivedRange(w, cr.start)
+			http.Error(w, fmt.Sprintf("Content-MD5 mismatch: expected %s, computed %s", expectedMD5, actualMD5), http.StatusBadRequest)
+			return
+		}
+	}
+
+	received = max(received, cr.end+1)
+	if cr.total < 0 || received < cr.total {
+		setReceivedRange(w, received)
+		w.WriteHeader(statusResumeIncomplete)
+		return
+	}
+
+	// Bytes beyond the total are left over from an earlier, longer attempt
+	if err := file.Truncate(cr.total); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to write chunk: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := file.Close(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to write chunk: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// Check again, another client may have replaced the file during the chunk
+	if !ifMatchHolds(ifMatch, fullPath) {
+		http.Error(w, "File has changed, ETag does not match", http.StatusPreconditionFailed)
+		return
+	}
+	stored, previous, err := placeUpload(partial, fullPath, createOnly, ifMatch)
+	if err != nil {
+		if os.IsExist(err) {
+			// The upload can't succeed any more, don't keep it around
+			os.Remove(partial)
+			http.Error(w, "File already exists", http.StatusPreconditionFailed)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to store file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	invalidateUsage(filepath.Dir(fullPath))
+	if stored != fullPath {
+		logf(r, "Keeping existing file %s, storing the upload as %s", fullPath, stored)
+		fullPath = stored
+		requestPath = path.Join(path.Dir(requestPath), filepath.Base(stored))
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to stat file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logf(r, "Uploaded file: %s (%d bytes, resumable)", fullPath, info.Size())
+	if verbose && previous != nil {
+		logOverwrite(r, fullPath, previous)
+	}
+	notifyUpload(r, requestPath, info.Size())
+
+	// Same statuses as a single PUT: 201 for a new file, 200 for a replaced one
+	status, message := http.StatusCreated, "File uploaded successfully"
+	if previous != nil {
+		status, message = http.StatusOK, "File replaced successfully"
+	} else {
+		w.Header().Set("Location", absoluteURL(r, requestPath))
+	}
+	if wantsJSON(r) {
+		digest, err := fileSHA256(fullPath, info)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error computing checksum: %v", err), http.StatusInternalServerError)
+			return
+		}
+		writeUploadJSON(w, status, newUploadResult(requestPath, info.Size(), digest))
+		return
+	}
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s: %s (%d bytes)\n", message, requestPath, info.Size())
+}
+
+// Handle ?resume=status queries - report how many bytes of a resumable upload
+// have been received so far, zero when it hasn't started
+func handleResumeStatus(w http.ResponseWriter, r *http.Request) {
+	requestPath := filepath.Clean(r.URL.Path)
+	root, fullPath := fsPath(requestPath)
+	if fullPath == root {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+	if !ensureWithinRoot(w, root, fullPath) {
+		return
+	}
+
+	if info, err := os.Stat(fullPath); err == nil && info.IsDir() {
+		http.Error(w, "Path is a directory", http.StatusConflict)
+		return
+	}
+	received, err := receivedBytes(fullPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error accessing partial upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Same Range convention as the 308 reply to a chunk
+	setReceivedRange(w, received)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		Received int64 `json:"received"`
+	}{received})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// uploadRouteMap is a flag.Value collecting repeated content-type=subdir pairs
+type uploadRouteMap map[string]string
+
+// Subdirectories uploads are stored under by Content-Type, from -route. Keys
+// are lowercase media types or "type/*" wildcards.
+var uploadRoutes = uploadRouteMap{}
+
+func (m uploadRouteMap) String() string {
+	pairs := make([]string, 0, len(m))
+	for mediaType, dir := range m {
+		pairs = append(pairs, mediaType+"="+dir)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set adds one content-type=subdir route
+func (m uploadRouteMap) Set(value string) error {
+	mediaType, dir, ok := strings.Cut(value, "=")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	dir = strings.Trim(strings.TrimSpace(dir), "/")
+	if !ok || mediaType == "" || dir == "" {
+		return errors.New("expected content-type=subdir")
+	}
+	major, minor, ok := strings.Cut(mediaType, "/")
+	if !ok || !isHeaderToken(major) || (minor != "*" && !isHeaderToken(minor)) {
+		return fmt.Errorf("invalid content type %q", mediaType)
+	}
+	if path.Clean(dir) != dir || dir == ".." || strings.HasPrefix(dir, "../") {
+		return fmt.Errorf("invalid subdirectory %q", dir)
+	}
+	m[mediaType] = dir
+	return nil
+}
+
+// lookup returns the subdirectory for a Content-Type header, preferring an
+// exact match over a wildcard, or an empty string
+func (m uploadRouteMap) lookup(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if dir, ok := m[mediaType]; ok {
+		return dir
+	}
+	major, _, _ := strings.Cut(mediaType, "/")
+	return m[major+"/*"]
+}
+
+// routeUpload inserts the -route subdirectory for the request's Content-Type
+// into requestPath, right below the root the path maps to. The new location
+// gets the checks the original path had in handleRequest; on failure an
+// error response is written and ok is false.
+func routeUpload(w http.ResponseWriter, r *http.Request, requestPath string) (routed string, ok bool) {
+	dir := uploadRoutes.lookup(r.Header.Get("Content-Type"))
+	if dir == "" {
+		return requestPath, true
+	}
+	// A -map prefix stays in front, so the upload lands in the mapped root
+	_, rel := resolveRoot(requestPath)
+	prefix := strings.TrimSuffix(path.Clean("/"+requestPath), rel)
+	routed = strings.TrimPrefix(path.Join(prefix, dir, rel), "/")
+
+	if !ensureNoDotfiles(w, routed) {
+		return routed, false
+	}
+	if maxDepth > 0 && pathDepth(routed) > maxDepth {
+		http.Error(w, fmt.Sprintf("Path exceeds the maximum depth of %d", maxDepth), http.StatusBadRequest)
+		return routed, false
+	}
+
+	// The routed directory may carry its own .access file
+	root, fullPath := fsPath(routed)
+	policy, _, err := accessPolicyFor(root, fullPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading access file: %v", err), http.StatusInternalServerError)
+		return routed, false
+	}
+	if !policy.allows(authenticatedUser(r), false) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return routed, false
+	}
+	return routed, true
+}
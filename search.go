@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errSearchLimit stops a walk once enough entries have been found
+var errSearchLimit = errors.New("search result limit reached")
+
+// searchResult is a single match, Path is relative to the searched directory
+type searchResult struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	IsDir   bool   `json:"is_dir"`
+	ModTime string `json:"mod_time"`
+}
+
+// searchTree walks dirPath looking for names containing term, ignoring case.
+// The walk stops at -search-max-depth levels and -search-max-results matches.
+func searchTree(r *http.Request, dirPath, term string) (results []searchResult, truncated bool, err error) {
+	term = strings.ToLower(term)
+	return walkTree(r, dirPath, searchMaxResults, searchMaxDepth, func(d fs.DirEntry) bool {
+		return strings.Contains(strings.ToLower(d.Name()), term)
+	})
+}
+
+// walkTree collects the entries below dirPath that match accepts, stopping
+// at maxDepth levels (0 means unlimited) and maxResults entries. In-progress
+// uploads, hidden names and subdirectories a .access file closes to r are
+// skipped. truncated reports whether the result limit cut the walk short.
+func walkTree(r *http.Request, dirPath string, maxResults, maxDepth int, match func(fs.DirEntry) bool) (results []searchResult, truncated bool, err error) {
+	err = filepath.WalkDir(dirPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable subdirectories shouldn't spoil the whole walk
+			if d != nil && d.IsDir() && p != dirPath {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if p == dirPath {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dirPath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		depth := strings.Count(rel, "/") + 1
+
+		// Skip in-progress uploads and anything hidden from listings
+		if !d.IsDir() && strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		if isHidden(d.Name()) || (d.IsDir() && !readableDir(r, p)) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if match(d) {
+			if len(results) >= maxResults {
+				truncated = true
+				return errSearchLimit
+			}
+			if info, err := d.Info(); err == nil {
+				results = append(results, searchResult{
+					Path:    rel,
+					Size:    info.Size(),
+					IsDir:   d.IsDir(),
+					ModTime: info.ModTime().Format(time.RFC3339),
+				})
+			}
+		}
+
+		if d.IsDir() && maxDepth > 0 && depth >= maxDepth {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if errors.Is(err, errSearchLimit) {
+		err = nil
+	}
+	return results, truncated, err
+}
+
+// serveSearch answers ?search=term on a directory with the matching entries
+// below it, as JSON or as an HTML list of links
+func serveSearch(w http.ResponseWriter, r *http.Request, dirPath, term string) {
+	results, truncated, err := searchTree(r, dirPath, term)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error searching directory: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logf(r, "Search for %q in %s: %d result(s)", term, dirPath, len(results))
+
+	if truncated {
+		w.Header().Set("X-Search-Truncated", "true")
+	}
+
+	if wantsJSON(r) {
+		w, closeGzip := maybeGzip(w, r, "application/json")
+		defer closeGzip()
+		w.Header().Set("Content-Type", "application/json")
+		if results == nil {
+			results = []searchResult{}
+		}
+		json.NewEncoder(w).Encode(results)
+		return
+	}
+
+	w, closeGzip := maybeGzip(w, r, "text/html")
+	defer closeGzip()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	title := html.EscapeString(fmt.Sprintf("Search results for %q in %s", term, r.URL.Path))
+	fmt.Fprintf(w, "<html><head><title>%s</title></head><body>\n", title)
+	fmt.Fprintf(w, "<h1>%s</h1>\n", title)
+	fmt.Fprintf(w, "<p><a href=\"%s\">Back to listing</a></p>\n<hr>\n<ul>\n", html.EscapeString(r.URL.Path))
+	for _, result := range results {
+		name := result.Path
+		if result.IsDir {
+			name += "/"
+		}
+		link := (&url.URL{Path: path.Join(r.URL.Path, result.Path)}).String()
+		size := "-"
+		if !result.IsDir {
+			size = humanizeBytes(result.Size)
+		}
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a> %s</li>\n", html.EscapeString(link), html.EscapeString(name), size)
+	}
+	fmt.Fprintf(w, "</ul>\n")
+	if len(results) == 0 {
+		fmt.Fprintf(w, "<p>No matches.</p>\n")
+	}
+	if truncated {
+		fmt.Fprintf(w, "<p>Showing the first %d matches only.</p>\n", len(results))
+	}
+	fmt.Fprintf(w, "<hr>\n</body></html>\n")
+}
+
+// serveTree answers ?recursive=1 on a directory with every file below it as
+// one flat JSON array, paths relative to the directory, so sync clients get
+// the whole tree in a single request. The walk stops at -tree-max-depth
+// levels and -tree-max-files files.
+func serveTree(w http.ResponseWriter, r *http.Request, dirPath string) {
+	files, truncated, err := walkTree(r, dirPath, treeMaxFiles, treeMaxDepth, func(d fs.DirEntry) bool {
+		return d.Type().IsRegular()
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error walking directory: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if truncated {
+		w.Header().Set("X-Tree-Truncated", "true")
+	}
+
+	w, closeGzip := maybeGzip(w, r, "application/json")
+	defer closeGzip()
+	w.Header().Set("Content-Type", "application/json")
+	if files == nil {
+		files = []searchResult{}
+	}
+	json.NewEncoder(w).Encode(files)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// transferStats counts the bytes served per file, keyed by URL path
+type transferStats struct {
+	mu     sync.Mutex
+	served map[string]int64
+}
+
+// Bytes served per file since the counters were first started
+var downloadStats = &transferStats{served: make(map[string]int64)}
+
+func (s *transferStats) add(key string, n int64) {
+	if n == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.served[key] += n
+	s.mu.Unlock()
+}
+
+// snapshot copies the counters and sums them up
+func (s *transferStats) snapshot() (files map[string]int64, total int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files = make(map[string]int64, len(s.served))
+	for key, n := range s.served {
+		files[key] = n
+		total += n
+	}
+	return files, total
+}
+
+// load restores counters saved by a previous run, a missing file is not an error
+func (s *transferStats) load(file string) error {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Unmarshal(data, &s.served)
+}
+
+// save writes the counters to file, atomically replacing the previous copy
+func (s *transferStats) save(file string) error {
+	files, _ := s.snapshot()
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".stats-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// statsKey names the file being served for /stats by its URL path
+func statsKey(r *http.Request, filePath string, info os.FileInfo) string {
+	key := path.Clean("/" + r.URL.Path)
+	switch {
+	case filePath == uploadDir:
+		// -d names a single file served on every path, count it just once
+		return "/" + info.Name()
+	case info.Name() != path.Base(key):
+		// The index file served for its directory
+		return path.Join(key, info.Name())
+	}
+	return key
+}
+
+// countTransfer wraps w so the bytes sent, after any compression, are added
+// to the counter for key by the returned func. That func must be called once
+// the response is complete.
+func countTransfer(w http.ResponseWriter, key string) (http.ResponseWriter, func()) {
+	rw := &responseWriter{ResponseWriter: w}
+	return rw, func() { downloadStats.add(key, rw.bytes) }
+}
+
+// Handle /stats requests - report the bytes served per file as JSON
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	files, total := downloadStats.snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		TotalBytes int64            `json:"total_bytes"`
+		Files      map[string]int64 `json:"files"`
+	}{total, files})
+}
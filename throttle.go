@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// newBandwidthLimiter returns a token bucket of bytesPerSec. The burst is
+// held to one copy buffer so transfers are paced smoothly rather than in
+// one-second spurts.
+func newBandwidthLimiter(bytesPerSec int64) *rate.Limiter {
+	burst := int(max(min(bytesPerSec, int64(bufferSize)), 1))
+	return rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+}
+
+// throttledReader paces reads from an upload body
+type throttledReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if burst := t.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		// Stop waiting as soon as the client goes away
+		if waitErr := t.limiter.WaitN(t.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// throttleUpload limits the request body of r to -upload-rate bytes per
+// second. Each request gets its own bucket.
+func throttleUpload(r *http.Request) {
+	if uploadBandwidth <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	r.Body = &throttledReader{ReadCloser: r.Body, ctx: r.Context(), limiter: newBandwidthLimiter(uploadBandwidth)}
+}
+
+// throttledWriter paces the body of a download
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), t.limiter.Burst())
+		if err := t.limiter.WaitN(t.ctx, n); err != nil {
+			return written, err
+		}
+		m, err := t.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (t *throttledWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// throttleDownload limits the response to a GET to -download-rate bytes per
+// second. Only what is actually sent counts, so a Range request is paced on
+// the slice it asked for.
+func throttleDownload(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if downloadBandwidth <= 0 || r.Method != http.MethodGet {
+		return w
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: r.Context(), limiter: newBandwidthLimiter(downloadBandwidth)}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// deadlineReader pushes the connection's read deadline forward after every
+// read that makes progress, so -read-timeout limits how long an upload may
+// stall rather than how long it may take in total
+type deadlineReader struct {
+	io.ReadCloser
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	if n > 0 {
+		d.rc.SetReadDeadline(time.Now().Add(d.timeout))
+	}
+	return n, err
+}
+
+// extendReadDeadline makes the read deadline of an upload follow its progress.
+// Without this, any body that takes longer than -read-timeout to arrive
+// would be cut off, however steadily it was flowing. Call it once an upload
+// slot is held: the deadline starts over here, so time spent queueing for
+// the slot doesn't count against the body.
+func extendReadDeadline(w http.ResponseWriter, r *http.Request) {
+	if readTimeout <= 0 {
+		return
+	}
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(readTimeout))
+	r.Body = &deadlineReader{
+		ReadCloser: r.Body,
+		rc:         rc,
+		timeout:    readTimeout,
+	}
+}
+
+// isTimeout reports whether err comes from an expired connection deadline
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
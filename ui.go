@@ -0,0 +1,21 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static/ui
+var uiFiles embed.FS
+
+// uiHandler serves the single-page file manager enabled by -ui. It talks to
+// the regular JSON listing, PUT and DELETE endpoints.
+func uiHandler() http.Handler {
+	content, err := fs.Sub(uiFiles, "static/ui")
+	if err != nil {
+		// The directory is embedded at build time, so this can't happen
+		panic(err)
+	}
+	return http.StripPrefix("/_ui/", http.FileServer(http.FS(content)))
+}
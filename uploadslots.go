@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Semaphore limiting simultaneous uploads, nil when -max-concurrent-uploads is 0
+var uploadSlots chan struct{}
+
+// acquireUploadSlot takes one of the -max-concurrent-uploads slots. When all
+// are busy it waits up to -upload-queue for one to free up, and otherwise
+// replies 503 and returns false. Callers must releaseUploadSlot when done.
+func acquireUploadSlot(w http.ResponseWriter, r *http.Request) bool {
+	if uploadSlots == nil {
+		return true
+	}
+
+	select {
+	case uploadSlots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if uploadQueueWait > 0 {
+		timer := time.NewTimer(uploadQueueWait)
+		defer timer.Stop()
+		select {
+		case uploadSlots <- struct{}{}:
+			return true
+		case <-timer.C:
+		case <-r.Context().Done():
+			return false
+		}
+	}
+
+	w.Header().Set("Retry-After", "5")
+	http.Error(w, "Too many concurrent uploads, try again later", http.StatusServiceUnavailable)
+	return false
+}
+
+// releaseUploadSlot frees a slot taken by acquireUploadSlot
+func releaseUploadSlot() {
+	if uploadSlots != nil {
+		<-uploadSlots
+	}
+}
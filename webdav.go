@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// WebDAV multi-status body. The D: prefix is written literally and bound to
+// the DAV: namespace on the root element.
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	Namespace string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	DisplayName   string          `xml:"D:displayname"`
+	ContentLength *int64          `xml:"D:getcontentlength,omitempty"`
+	LastModified  string          `xml:"D:getlastmodified"`
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+// davEntry describes one file or directory for a PROPFIND response
+func davEntry(urlPath string, info os.FileInfo) davResponse {
+	prop := davProp{
+		DisplayName:  info.Name(),
+		LastModified: info.ModTime().UTC().Format(http.TimeFormat),
+	}
+	href := urlPath
+	if info.IsDir() {
+		prop.ResourceType.Collection = &struct{}{}
+		if !strings.HasSuffix(href, "/") {
+			href += "/"
+		}
+	} else {
+		size := info.Size()
+		prop.ContentLength = &size
+	}
+	return davResponse{
+		Href: (&url.URL{Path: href}).String(),
+		Propstat: davPropstat{
+			Prop:   prop,
+			Status: "HTTP/1.1 200 OK",
+		},
+	}
+}
+
+// Handle PROPFIND requests - a minimal, read-only subset of WebDAV that
+// reports the basic properties of a path and, unless Depth is 0, those of
+// its children. The request body is ignored and the same properties are
+// always returned.
+func handlePropfind(w http.ResponseWriter, r *http.Request) {
+	requestPath, fullPath, info, ok := statRequestPath(w, r)
+	if !ok {
+		return
+	}
+	io.Copy(io.Discard, r.Body)
+
+	// Listing children is a directory listing, just like a GET
+	if noListing && info.IsDir() && r.Header.Get("Depth") != "0" {
+		http.Error(w, "Directory listing is disabled", http.StatusForbidden)
+		return
+	}
+
+	status := davMultistatus{Namespace: "DAV:"}
+	status.Responses = append(status.Responses, davEntry(requestPath, info))
+
+	// Depth 1 and infinity both list the immediate children only
+	if info.IsDir() && r.Header.Get("Depth") != "0" {
+		entries, err := os.ReadDir(fullPath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading directory: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for _, entry := range filterHidden(entries) {
+			childInfo, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			status.Responses = append(status.Responses, davEntry(path.Join(requestPath, entry.Name()), childInfo))
+		}
+	}
+
+	body, err := xml.Marshal(status)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	w.Write(body)
+}
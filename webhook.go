@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	webhookTimeout  = 5 * time.Second
+	webhookAttempts = 3
+	webhookQueueLen = 100
+)
+
+// uploadEvent is the JSON payload posted to -webhook-url
+type uploadEvent struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	RemoteAddr string `json:"remote_addr"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// webhookNotifier delivers upload events from a single background worker so
+// slow or failing receivers never hold up the upload itself
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+	events chan uploadEvent
+}
+
+// Upload notifier, nil unless -webhook-url is set
+var webhooks *webhookNotifier
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	n := &webhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		events: make(chan uploadEvent, webhookQueueLen),
+	}
+	go n.run()
+	return n
+}
+
+func (n *webhookNotifier) run() {
+	for event := range n.events {
+		n.deliver(event)
+	}
+}
+
+// deliver posts one event, retrying with a growing delay between attempts
+func (n *webhookNotifier) deliver(event uploadEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Webhook: failed to encode event for %s: %v", event.Path, err)
+		return
+	}
+
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if err = n.post(payload); err == nil {
+			return
+		}
+		if attempt < webhookAttempts {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+	log.Printf("Webhook: giving up on %s after %d attempts: %v", event.Path, webhookAttempts, err)
+}
+
+func (n *webhookNotifier) post(payload []byte) error {
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyUpload queues a webhook for a completed upload. Events are dropped
+// rather than blocking the request when the queue is full.
+func notifyUpload(r *http.Request, requestPath string, size int64) {
+	if webhooks == nil {
+		return
+	}
+
+	event := uploadEvent{
+		Path:       "/" + strings.TrimPrefix(requestPath, "/"),
+		Size:       size,
+		RemoteAddr: clientIP(r),
+		Timestamp:  time.Now().Format(time.RFC3339),
+	}
+	select {
+	case webhooks.events <- event:
+	default:
+		log.Printf("Webhook: queue full, dropping event for %s", event.Path)
+	}
+}